@@ -0,0 +1,147 @@
+package patcher
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+
+	"github.com/cccteam/ccc/accesstypes"
+	"github.com/go-playground/errors/v5"
+)
+
+// auditTagName is the struct tag controlling how a field is recorded in the ChangeSet of data change events,
+// e.g. `spanner:"Password" audit:"redact"`. The field is always written to the table.
+const auditTagName = "audit"
+
+type auditOption string
+
+const (
+	// auditRedact records changed values as redactedValue
+	auditRedact auditOption = "redact"
+
+	// auditHash records changed values as the HMAC-SHA256 of their JSON encoding, keyed with the key set with WithAuditHashKey,
+	// so changes can be compared without recording the value. Without the key, values with few possibilities cannot be
+	// recovered by hashing each of them.
+	auditHash auditOption = "hash"
+
+	// auditOmit leaves the field out of the ChangeSet
+	auditOmit auditOption = "omit"
+)
+
+// redactedValue is recorded in place of the values of fields with the audit option redact
+const redactedValue = "[REDACTED]"
+
+// hashPrefix prefixes the values recorded for fields with the audit option hash
+const hashPrefix = "hmac-sha256:"
+
+// auditConfig selects the tables whose changes are recorded in data change events, and holds the key of the audit option hash
+type auditConfig struct {
+	hashKey []byte
+
+	// unaudited is set when tables are not audited unless enabled in tables
+	unaudited bool
+	tables    map[accesstypes.Resource]bool
+}
+
+// setTables enables or disables the data change events of tableNames, or of all tables when none are given
+func (c *auditConfig) setTables(enabled bool, tableNames []accesstypes.Resource) {
+	if len(tableNames) == 0 {
+		c.unaudited = !enabled
+		c.tables = nil
+
+		return
+	}
+
+	if c.tables == nil {
+		c.tables = make(map[accesstypes.Resource]bool, len(tableNames))
+	}
+	for _, tableName := range tableNames {
+		c.tables[tableName] = enabled
+	}
+}
+
+// audited reports whether the changes of tableName are recorded in data change events
+func (c *auditConfig) audited(tableName accesstypes.Resource) bool {
+	if enabled, ok := c.tables[tableName]; ok {
+		return enabled
+	}
+
+	return !c.unaudited
+}
+
+// fieldAudit returns the audit option of field in rowType
+func fieldAudit(rowType reflect.Type, field accesstypes.Field) (auditOption, error) {
+	structField, ok := rowType.FieldByName(string(field))
+	if !ok {
+		return "", errors.Newf("field %s not found in struct", field)
+	}
+
+	switch option := auditOption(structField.Tag.Get(auditTagName)); option {
+	case "", auditRedact, auditHash, auditOmit:
+		return option, nil
+	default:
+		return "", errors.Newf("field %s has unsupported audit option %q", field, option)
+	}
+}
+
+// isMasked reports whether the values of field in rowType are recorded masked, so they cannot be restored from a ChangeSet
+func isMasked(rowType reflect.Type, field accesstypes.Field) bool {
+	option, err := fieldAudit(rowType, field)
+	if err != nil {
+		return false
+	}
+
+	return option == auditRedact || option == auditHash
+}
+
+// auditChangeSet applies the audit options of the fields of rowType to changeSet, hashing with hashKey. Zero values are recorded
+// as is, so the ChangeSet of an insert or a delete keeps its shape, and only reveals that the field was empty.
+func auditChangeSet[K ~string](rowType reflect.Type, changeSet map[K]DiffElem, hashKey []byte) error {
+	for field, elem := range changeSet {
+		option, err := fieldAudit(rowType, accesstypes.Field(field))
+		if err != nil {
+			return err
+		}
+
+		switch option {
+		case auditOmit:
+			delete(changeSet, field)
+		case auditRedact, auditHash:
+			if elem.Old, err = maskValue(option, elem.Old, hashKey); err != nil {
+				return errors.Wrapf(err, "field %s", field)
+			}
+			if elem.New, err = maskValue(option, elem.New, hashKey); err != nil {
+				return errors.Wrapf(err, "field %s", field)
+			}
+			changeSet[field] = elem
+		}
+	}
+
+	return nil
+}
+
+// maskValue returns the value recorded in place of v for option
+func maskValue(option auditOption, v any, hashKey []byte) (any, error) {
+	if value := reflect.ValueOf(v); !value.IsValid() || value.IsZero() {
+		return v, nil
+	}
+
+	if option == auditRedact {
+		return redactedValue, nil
+	}
+
+	if len(hashKey) == 0 {
+		return nil, errors.New("the audit option hash requires a key, see WithAuditHashKey")
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.Wrap(err, "json.Marshal()")
+	}
+	mac := hmac.New(sha256.New, hashKey)
+	mac.Write(b)
+
+	return hashPrefix + hex.EncodeToString(mac.Sum(nil)), nil
+}
@@ -0,0 +1,108 @@
+package patcher
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cccteam/ccc/accesstypes"
+)
+
+func Test_auditChangeSet(t *testing.T) {
+	t.Parallel()
+
+	type Account struct {
+		Name     string `spanner:"Name"`
+		Password string `spanner:"Password" audit:"redact"`
+		Pin      int64  `spanner:"Pin"      audit:"hash"`
+		Token    string `spanner:"Token"    audit:"omit"`
+		Invalid  string `spanner:"Invalid"  audit:"encrypt"`
+	}
+
+	tests := []struct {
+		name      string
+		changeSet map[accesstypes.Field]DiffElem
+		hashKey   []byte
+		want      map[accesstypes.Field]DiffElem
+		wantErr   bool
+	}{
+		{
+			name: "update",
+			changeSet: map[accesstypes.Field]DiffElem{
+				"Name":     {Old: "ann", New: "bob"},
+				"Password": {Old: "old", New: "new"},
+				"Pin":      {Old: int64(1234), New: int64(0)},
+				"Token":    {Old: "abc", New: "def"},
+			},
+			hashKey: []byte("audit key"),
+			want: map[accesstypes.Field]DiffElem{
+				"Name":     {Old: "ann", New: "bob"},
+				"Password": {Old: redactedValue, New: redactedValue},
+				"Pin":      {Old: "hmac-sha256:0aa98ac0159f06003919fa1c26952bb5a1b6eef2fc4e3b17f29dc8c48c733cdd", New: int64(0)},
+			},
+		},
+		{
+			name:      "delete",
+			changeSet: map[accesstypes.Field]DiffElem{"Password": {Old: "old"}},
+			want:      map[accesstypes.Field]DiffElem{"Password": {Old: redactedValue}},
+		},
+		{
+			name:      "hash without key",
+			changeSet: map[accesstypes.Field]DiffElem{"Pin": {Old: int64(1234), New: int64(5678)}},
+			wantErr:   true,
+		},
+		{
+			name:      "unsupported option",
+			changeSet: map[accesstypes.Field]DiffElem{"Invalid": {Old: "", New: "x"}},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := auditChangeSet(reflect.TypeOf(Account{}), tt.changeSet, tt.hashKey)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("auditChangeSet() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(tt.changeSet, tt.want) {
+				t.Errorf("auditChangeSet() = (%v),  want (%v)", tt.changeSet, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeChangeSet_audit(t *testing.T) {
+	t.Parallel()
+
+	type Account struct {
+		ID  string `spanner:"Id"`
+		Pin int64  `spanner:"Pin" audit:"redact"`
+	}
+
+	changeSet := `{"ID":{"Old":"1","New":null},"Pin":{"Old":"[REDACTED]","New":null}}`
+
+	got, err := DecodeChangeSet(changeSet, NewRowStruct(Account{}))
+	if err != nil {
+		t.Fatalf("DecodeChangeSet() error = %v", err)
+	}
+	want := map[accesstypes.Field]DiffElem{"ID": {Old: "1"}, "Pin": {Old: redactedValue}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeChangeSet() = (%v),  want (%v)", got, want)
+	}
+
+	raw, err := parseChangeSet(changeSet)
+	if err != nil {
+		t.Fatalf("parseChangeSet() error = %v", err)
+	}
+	deleted, err := deletedRow(raw, NewRowStruct(Account{}))
+	if err != nil {
+		t.Fatalf("deletedRow() error = %v", err)
+	}
+	if want := (&Account{ID: "1"}); !reflect.DeepEqual(deleted, want) {
+		t.Errorf("deletedRow() = (%v),  want (%v)", deleted, want)
+	}
+}
@@ -0,0 +1,46 @@
+package patcher
+
+import "context"
+
+type ctxKey string
+
+const (
+	ctxCorrelationID ctxKey = "correlationID"
+	ctxChangeReason  ctxKey = "changeReason"
+)
+
+// WithCorrelationID returns a copy of ctx carrying correlationID, which is recorded on the data change events of changes made with it,
+// e.g. a trace or request ID grouping the changes made by a request. It is only recorded when CorrelationIDColumn is enabled.
+func WithCorrelationID(ctx context.Context, correlationID string) context.Context {
+	return context.WithValue(ctx, ctxCorrelationID, correlationID)
+}
+
+// WithChangeReason returns a copy of ctx carrying reason, which is recorded on the data change events of changes made with it.
+// It is only recorded when ReasonColumn is enabled.
+func WithChangeReason(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, ctxChangeReason, reason)
+}
+
+// CorrelationIDFromCtx returns the correlation ID carried by ctx
+func CorrelationIDFromCtx(ctx context.Context) (string, bool) {
+	correlationID, ok := ctx.Value(ctxCorrelationID).(string)
+
+	return correlationID, ok
+}
+
+// ChangeReasonFromCtx returns the change reason carried by ctx
+func ChangeReasonFromCtx(ctx context.Context) (string, bool) {
+	reason, ok := ctx.Value(ctxChangeReason).(string)
+
+	return reason, ok
+}
+
+// setFromContext sets the fields of e carried by ctx
+func (e *DataChangeEvent) setFromContext(ctx context.Context) {
+	if correlationID, ok := CorrelationIDFromCtx(ctx); ok {
+		e.CorrelationID = &correlationID
+	}
+	if reason, ok := ChangeReasonFromCtx(ctx); ok {
+		e.Reason = &reason
+	}
+}
@@ -0,0 +1,201 @@
+package patcher
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/cccteam/ccc/accesstypes"
+	"github.com/cccteam/ccc/resource"
+	"github.com/go-playground/errors/v5"
+)
+
+// rawDiffElem is a DiffElem read back from a ChangeSet, with its values left encoded
+type rawDiffElem struct {
+	Old json.RawMessage
+	New json.RawMessage
+}
+
+// rawChangeSet is the ChangeSet of a DataChangeEvent, with its values left encoded
+type rawChangeSet map[accesstypes.Field]rawDiffElem
+
+func parseChangeSet(changeSet string) (rawChangeSet, error) {
+	var c rawChangeSet
+	if err := json.Unmarshal([]byte(changeSet), &c); err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal()")
+	}
+
+	return c, nil
+}
+
+// isDelete reports whether the ChangeSet was recorded for a delete, which has no New values
+func (c rawChangeSet) isDelete() bool {
+	if len(c) == 0 {
+		return false
+	}
+
+	for _, elem := range c {
+		if !isNull(elem.New) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasKeys reports whether the ChangeSet records a key field of keySet, which only the ChangeSets of deletes and restores do
+func (c rawChangeSet) hasKeys(keySet resource.KeySet) bool {
+	for _, part := range keySet.Parts() {
+		if _, ok := c[part.Key]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasZeroOld reports whether every Old value of the ChangeSet is a zero value, as in the ChangeSet of an insert.
+// The ChangeSet of an update from zero values has zero Old values too.
+func (c rawChangeSet) hasZeroOld() bool {
+	for _, elem := range c {
+		if !isZero(elem.Old) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// inverse returns the ChangeSet undoing c
+func (c rawChangeSet) inverse() rawChangeSet {
+	inverse := make(rawChangeSet, len(c))
+	for field, elem := range c {
+		inverse[field] = rawDiffElem{Old: elem.New, New: elem.Old}
+	}
+
+	return inverse
+}
+
+func isNull(raw json.RawMessage) bool {
+	return len(raw) == 0 || string(raw) == "null"
+}
+
+// isZero reports whether raw is the JSON encoding of a zero value
+func isZero(raw json.RawMessage) bool {
+	if isNull(raw) {
+		return true
+	}
+
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return false
+	}
+
+	switch t := v.(type) {
+	case string:
+		return t == "" || t == zeroTime || t == zeroUUID
+	case float64:
+		return t == 0
+	case bool:
+		return !t
+	case []any:
+		return len(t) == 0
+	case map[string]any:
+		return len(t) == 0
+	default:
+		return false
+	}
+}
+
+const (
+	zeroTime = "0001-01-01T00:00:00Z"
+	zeroUUID = "00000000-0000-0000-0000-000000000000"
+)
+
+// rowColumns returns the values of the tagged fields of v, a pointer to a row struct, by column name
+func (p *patcher) rowColumns(v any) (map[string]any, error) {
+	fieldTagMapping, err := p.get(v)
+	if err != nil {
+		return nil, err
+	}
+
+	value := reflect.Indirect(reflect.ValueOf(v))
+	columns := make(map[string]any, len(fieldTagMapping))
+	for _, c := range fieldTagMapping {
+		columns[c.tag] = value.Field(c.index).Interface()
+	}
+
+	return columns, nil
+}
+
+// deletedRow rebuilds the row removed by a delete ChangeSet from its Old values.
+// Fields which are not in the ChangeSet had their zero value when the row was deleted.
+// Masked fields are left with their zero value, since their values were not recorded.
+func deletedRow(changeSet rawChangeSet, row RowStruct) (any, error) {
+	rowType := rowStructType(row)
+	v := reflect.New(rowType)
+	for field, elem := range changeSet {
+		if isMasked(rowType, field) {
+			continue
+		}
+
+		value, err := decodeField(rowType, field, elem.Old)
+		if err != nil {
+			return nil, err
+		}
+		v.Elem().FieldByName(string(field)).Set(reflect.ValueOf(value))
+	}
+
+	return v.Interface(), nil
+}
+
+// DecodeChangeSet decodes the ChangeSet of a DataChangeEvent recorded for row, unmarshaling each Old and New value
+// into the type of its field in row, e.g. int64 rather than float64 and time.Time rather than string.
+// A null value, such as the New values of a delete, is decoded as nil, and the values of fields
+// with the audit option redact or hash are decoded as the string recorded in place of the value.
+func DecodeChangeSet(changeSet string, row RowStruct) (map[accesstypes.Field]DiffElem, error) {
+	raw, err := parseChangeSet(changeSet)
+	if err != nil {
+		return nil, err
+	}
+
+	rowType := rowStructType(row)
+	diff := make(map[accesstypes.Field]DiffElem, len(raw))
+	for field, elem := range raw {
+		oldValue, err := decodeValue(rowType, field, elem.Old)
+		if err != nil {
+			return nil, err
+		}
+
+		newValue, err := decodeValue(rowType, field, elem.New)
+		if err != nil {
+			return nil, err
+		}
+
+		diff[field] = DiffElem{Old: oldValue, New: newValue}
+	}
+
+	return diff, nil
+}
+
+// decodeValue is decodeField, except that a null value is decoded as nil,
+// and the masked values of fields with the audit option redact or hash are decoded as strings
+func decodeValue(rowType reflect.Type, field accesstypes.Field, raw json.RawMessage) (any, error) {
+	if isNull(raw) {
+		if _, ok := rowType.FieldByName(string(field)); !ok {
+			return nil, errors.Newf("field %s not found in struct", field)
+		}
+
+		return nil, nil
+	}
+
+	if isMasked(rowType, field) && raw[0] == '"' {
+		var masked string
+		if err := json.Unmarshal(raw, &masked); err != nil {
+			return nil, errors.Wrapf(err, "json.Unmarshal(): field %s", field)
+		}
+
+		return masked, nil
+	}
+
+	return decodeField(rowType, field, raw)
+}
@@ -0,0 +1,104 @@
+package patcher
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cccteam/ccc"
+	"github.com/cccteam/ccc/accesstypes"
+)
+
+func Test_deletedRow(t *testing.T) {
+	t.Parallel()
+
+	type Fruit struct {
+		ID    string `spanner:"Id"`
+		Name  string `spanner:"Name"`
+		Count int64  `spanner:"Count"`
+		Notes string
+	}
+
+	changeSet, err := parseChangeSet(`{"ID":{"Old":"1","New":null},"Name":{"Old":"apple","New":null}}`)
+	if err != nil {
+		t.Fatalf("parseChangeSet() error = %v", err)
+	}
+	if !changeSet.isDelete() {
+		t.Errorf("rawChangeSet.isDelete() = false, want true")
+	}
+	if changeSet.inverse().isDelete() {
+		t.Errorf("rawChangeSet.inverse().isDelete() = true, want false")
+	}
+
+	deleted, err := deletedRow(changeSet, NewRowStruct(Fruit{}))
+	if err != nil {
+		t.Fatalf("deletedRow() error = %v", err)
+	}
+	if want := (&Fruit{ID: "1", Name: "apple"}); !reflect.DeepEqual(deleted, want) {
+		t.Errorf("deletedRow() = (%v),  want (%v)", deleted, want)
+	}
+
+	columns, err := newPatcher("spanner", SpannerDialect{}).rowColumns(deleted)
+	if err != nil {
+		t.Fatalf("patcher.rowColumns() error = %v", err)
+	}
+	if want := map[string]any{"Id": "1", "Name": "apple", "Count": int64(0)}; !reflect.DeepEqual(columns, want) {
+		t.Errorf("patcher.rowColumns() = (%v),  want (%v)", columns, want)
+	}
+
+	if _, err := deletedRow(rawChangeSet{accesstypes.Field("Color"): {Old: []byte(`"red"`)}}, NewRowStruct(Fruit{})); err == nil {
+		t.Errorf("deletedRow() error = nil, want error for unknown field")
+	}
+}
+
+func TestDecodeChangeSet(t *testing.T) {
+	t.Parallel()
+
+	type Fruit struct {
+		ID       ccc.UUID   `spanner:"Id"`
+		Count    int64      `spanner:"Count"`
+		PickedAt *time.Time `spanner:"PickedAt"`
+	}
+
+	id := ccc.Must(ccc.UUIDFromString("a517b48d-63a9-4c1f-b45b-8474b164e423"))
+	pickedAt := time.Date(2032, 4, 23, 12, 2, 3, 4, time.UTC)
+
+	tests := []struct {
+		name      string
+		changeSet string
+		want      map[accesstypes.Field]DiffElem
+		wantErr   bool
+	}{
+		{
+			name:      "update",
+			changeSet: `{"Count":{"Old":1,"New":2},"PickedAt":{"Old":null,"New":"2032-04-23T12:02:03.000000004Z"}}`,
+			want: map[accesstypes.Field]DiffElem{
+				"Count":    {Old: int64(1), New: int64(2)},
+				"PickedAt": {Old: nil, New: &pickedAt},
+			},
+		},
+		{
+			name:      "delete",
+			changeSet: `{"ID":{"Old":"a517b48d-63a9-4c1f-b45b-8474b164e423","New":null}}`,
+			want: map[accesstypes.Field]DiffElem{
+				"ID": {Old: id, New: nil},
+			},
+		},
+		{name: "unknown field", changeSet: `{"Color":{"Old":null,"New":null}}`, wantErr: true},
+		{name: "wrong type", changeSet: `{"Count":{"Old":"one","New":2}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := DecodeChangeSet(tt.changeSet, NewRowStruct(Fruit{}))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeChangeSet() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) && !tt.wantErr {
+				t.Errorf("DecodeChangeSet() = (%v),  want (%v)", got, tt.want)
+			}
+		})
+	}
+}
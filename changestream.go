@@ -0,0 +1,334 @@
+package patcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/cccteam/ccc/accesstypes"
+	"github.com/cccteam/ccc/resource"
+	"github.com/go-playground/errors/v5"
+)
+
+// ModType is the kind of change of a change stream data change record
+type ModType string
+
+const (
+	ModTypeInsert ModType = "INSERT"
+	ModTypeUpdate ModType = "UPDATE"
+	ModTypeDelete ModType = "DELETE"
+)
+
+// ChangeStreamRecord is a data change record of a Spanner change stream
+type ChangeStreamRecord struct {
+	CommitTimestamp time.Time
+	TableName       accesstypes.Resource
+	ModType         ModType
+	Mods            []ChangeStreamMod
+}
+
+// ChangeStreamMod is the change of a row in a ChangeStreamRecord. Keys, OldValues and NewValues are JSON objects
+// keyed by column name, encoded the way Spanner encodes them in change streams, e.g. INT64 values are JSON strings.
+// Keys lists the key columns in primary key order.
+type ChangeStreamMod struct {
+	Keys      json.RawMessage
+	OldValues json.RawMessage
+	NewValues json.RawMessage
+}
+
+// ChangeStreamReader reads the data change records of a Spanner change stream
+type ChangeStreamReader interface {
+	// ReadChangeStream calls fn with each data change record of the stream until ctx is done, or fn returns an error
+	ReadChangeStream(ctx context.Context, fn func(ctx context.Context, record *ChangeStreamRecord) error) error
+}
+
+// StreamChange is the change of a row read from a change stream, in the shape of the ChangeSet of a DataChangeEvent
+type StreamChange struct {
+	TableName       accesstypes.Resource
+	KeySet          resource.KeySet
+	CommitTimestamp time.Time
+	EventType       EventType
+	ChangeSet       map[accesstypes.Field]DiffElem
+	RowStruct       RowStruct
+
+	// newValues are the New values of the changed fields before the audit options were applied to ChangeSet
+	newValues map[accesstypes.Field]any
+}
+
+// Mutation returns the mutation making the change. The values of fields with an audit option are the values
+// written by the change, not the values recorded in ChangeSet in their place.
+func (c *StreamChange) Mutation() *Mutation {
+	mutation := &Mutation{TableName: c.TableName, RowStruct: c.RowStruct, PatchSet: resource.NewPatchSet()}
+	switch c.EventType {
+	case EventTypeInsert:
+		mutation.Operation = OperationCreate
+	case EventTypeDelete:
+		mutation.Operation = OperationDelete
+	default:
+		mutation.Operation = OperationUpdate
+	}
+
+	for _, part := range c.KeySet.Parts() {
+		mutation.PatchSet.SetKey(part.Key, part.Value)
+	}
+
+	if c.EventType != EventTypeDelete {
+		if c.newValues != nil {
+			for field, value := range c.newValues {
+				mutation.PatchSet.Set(field, value)
+			}
+		} else {
+			for field, elem := range c.ChangeSet {
+				mutation.PatchSet.Set(field, elem.New)
+			}
+		}
+	}
+
+	return mutation
+}
+
+// ConsumeChangeStream reads the change stream with reader, and calls fn with the change of each row of the tables in rowStructs.
+// Records of other tables are skipped.
+func (p *SpannerPatcher) ConsumeChangeStream(
+	ctx context.Context, reader ChangeStreamReader, rowStructs map[accesstypes.Resource]RowStruct, fn func(ctx context.Context, change *StreamChange) error,
+) error {
+	if err := reader.ReadChangeStream(ctx, func(ctx context.Context, record *ChangeStreamRecord) error {
+		row, ok := rowStructs[record.TableName]
+		if !ok {
+			return nil
+		}
+
+		changes, err := p.StreamChanges(record, row)
+		if err != nil {
+			return err
+		}
+
+		for _, change := range changes {
+			if err := fn(ctx, change); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "ChangeStreamReader.ReadChangeStream()")
+	}
+
+	return nil
+}
+
+// StreamChanges converts the mods of record into the changes of the rows of row, with the same ChangeSet as a DataChangeEvent:
+// inserts have the zero value as Old, deletes have the non-zero values of the row as Old, and updates only have the
+// changed fields. Updates of streams not capturing old values have nil Old values. Columns not in row are skipped,
+// and the audit options of the fields of row are applied to the ChangeSet, but not to the StreamChange.Mutation.
+func (p *SpannerPatcher) StreamChanges(record *ChangeStreamRecord, row RowStruct) ([]*StreamChange, error) {
+	fieldTagMapping, err := p.get(row.Type())
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]accesstypes.Field, len(fieldTagMapping))
+	for field, c := range fieldTagMapping {
+		fields[c.tag] = field
+	}
+
+	var eventType EventType
+	switch record.ModType {
+	case ModTypeInsert:
+		eventType = EventTypeInsert
+	case ModTypeUpdate:
+		eventType = EventTypeUpdate
+	case ModTypeDelete:
+		eventType = EventTypeDelete
+	default:
+		return nil, errors.Newf("unsupported mod type %q", record.ModType)
+	}
+
+	rowType := rowStructType(row)
+	changes := make([]*StreamChange, 0, len(record.Mods))
+	for _, mod := range record.Mods {
+		keyColumns, keys, err := decodeStreamValues(rowType, fields, mod.Keys)
+		if err != nil {
+			return nil, err
+		}
+
+		var keySet resource.KeySet
+		for i, column := range keyColumns {
+			if i == 0 {
+				keySet = resource.NewKeySet(fields[column], keys[column])
+			} else {
+				keySet = keySet.Add(fields[column], keys[column])
+			}
+		}
+
+		_, oldValues, err := decodeStreamValues(rowType, fields, mod.OldValues)
+		if err != nil {
+			return nil, err
+		}
+
+		newColumns, newValues, err := decodeStreamValues(rowType, fields, mod.NewValues)
+		if err != nil {
+			return nil, err
+		}
+
+		changeSet := make(map[accesstypes.Field]DiffElem)
+		switch eventType {
+		case EventTypeDelete:
+			for _, values := range []map[string]any{keys, oldValues} {
+				for column, value := range values {
+					if !reflect.ValueOf(value).IsZero() {
+						changeSet[fields[column]] = DiffElem{Old: value}
+					}
+				}
+			}
+		default:
+			for _, column := range newColumns {
+				newValue := newValues[column]
+				oldValue, ok := oldValues[column]
+				if !ok && eventType == EventTypeInsert {
+					oldValue = reflect.Zero(reflect.TypeOf(newValue)).Interface()
+				}
+
+				if ok || eventType == EventTypeInsert {
+					if matched, err := match(oldValue, newValue); err != nil {
+						return nil, err
+					} else if matched {
+						continue
+					}
+				}
+
+				changeSet[fields[column]] = DiffElem{Old: oldValue, New: newValue}
+			}
+		}
+
+		// The values written by the change are kept for Mutation, before the ChangeSet is masked
+		var written map[accesstypes.Field]any
+		if eventType != EventTypeDelete {
+			written = make(map[accesstypes.Field]any, len(changeSet))
+			for field, elem := range changeSet {
+				written[field] = elem.New
+			}
+		}
+
+		if err := auditChangeSet(rowType, changeSet, p.audit.hashKey); err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, &StreamChange{
+			TableName:       record.TableName,
+			KeySet:          keySet,
+			CommitTimestamp: record.CommitTimestamp,
+			EventType:       eventType,
+			ChangeSet:       changeSet,
+			RowStruct:       row,
+			newValues:       written,
+		})
+	}
+
+	return changes, nil
+}
+
+// decodeStreamValues decodes the JSON object raw of change stream values, returning its columns in order, and their values
+// decoded into the type of their field in rowType. Columns not in fields are skipped.
+func decodeStreamValues(rowType reflect.Type, fields map[string]accesstypes.Field, raw json.RawMessage) ([]string, map[string]any, error) {
+	values := make(map[string]any)
+	if len(raw) == 0 {
+		return nil, values, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, errors.Wrap(err, "json.Decoder.Token()")
+	}
+
+	var columns []string
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "json.Decoder.Token()")
+		}
+		column, _ := token.(string)
+
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, nil, errors.Wrapf(err, "json.Decoder.Decode(): column %s", column)
+		}
+
+		field, ok := fields[column]
+		if !ok {
+			continue
+		}
+
+		structField, _ := rowType.FieldByName(string(field))
+		v, err := decodeStreamValue(structField.Type, value)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "column %s", column)
+		}
+
+		columns = append(columns, column)
+		values[column] = v
+	}
+
+	return columns, values, nil
+}
+
+var nullInt64Type = reflect.TypeOf(spanner.NullInt64{})
+
+// decodeStreamValue decodes raw into a value of type t. Change streams encode INT64 values as JSON
+// strings, so they are unquoted when t is an integer type, or a pointer or slice of one. BYTES are base64 strings, decoded as []byte.
+func decodeStreamValue(t reflect.Type, raw json.RawMessage) (any, error) {
+	elemType := t
+	for elemType.Kind() == reflect.Pointer {
+		elemType = elemType.Elem()
+	}
+
+	switch {
+	case isIntType(elemType):
+		raw = unquote(raw)
+	case elemType.Kind() == reflect.Slice && elemType.Elem().Kind() != reflect.Uint8 && isIntType(elemType.Elem()):
+		var elems []json.RawMessage
+		if err := json.Unmarshal(raw, &elems); err != nil {
+			return nil, errors.Wrap(err, "json.Unmarshal()")
+		}
+
+		if elems != nil {
+			for i := range elems {
+				elems[i] = unquote(elems[i])
+			}
+
+			var err error
+			if raw, err = json.Marshal(elems); err != nil {
+				return nil, errors.Wrap(err, "json.Marshal()")
+			}
+		}
+	}
+
+	value := reflect.New(t)
+	if err := json.Unmarshal(raw, value.Interface()); err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal()")
+	}
+
+	return value.Elem().Interface(), nil
+}
+
+func isIntType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+
+	return t == nullInt64Type
+}
+
+// unquote returns the contents of raw when it is a JSON string
+func unquote(raw json.RawMessage) json.RawMessage {
+	if len(raw) >= 2 && raw[0] == '"' && raw[len(raw)-1] == '"' {
+		return raw[1 : len(raw)-1]
+	}
+
+	return raw
+}
@@ -0,0 +1,218 @@
+package patcher
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cccteam/ccc/accesstypes"
+	"github.com/cccteam/ccc/resource"
+)
+
+type fakeChangeStreamReader []*ChangeStreamRecord
+
+func (r fakeChangeStreamReader) ReadChangeStream(ctx context.Context, fn func(ctx context.Context, record *ChangeStreamRecord) error) error {
+	for _, record := range r {
+		if err := fn(ctx, record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func TestSpannerPatcher_ConsumeChangeStream(t *testing.T) {
+	t.Parallel()
+
+	type Fruit struct {
+		Region  string  `spanner:"Region"`
+		ID      int64   `spanner:"Id"`
+		Name    string  `spanner:"Name"`
+		Count   *int64  `spanner:"Count"`
+		Ratings []int64 `spanner:"Ratings"`
+		Data    []byte  `spanner:"Data"`
+	}
+
+	commitTimestamp := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	count := int64(3)
+	keySet := resource.NewKeySet("Region", "west").Add("ID", int64(7))
+
+	tests := []struct {
+		name    string
+		record  *ChangeStreamRecord
+		want    *StreamChange
+		wantErr bool
+	}{
+		{
+			name: "insert",
+			record: &ChangeStreamRecord{
+				CommitTimestamp: commitTimestamp, TableName: "Fruits", ModType: ModTypeInsert,
+				Mods: []ChangeStreamMod{{
+					Keys:      []byte(`{"Region":"west","Id":"7"}`),
+					NewValues: []byte(`{"Name":"apple","Count":"3","Ratings":["1","2"],"Data":"AQI=","Unknown":true}`),
+				}},
+			},
+			want: &StreamChange{
+				TableName: "Fruits", KeySet: keySet, CommitTimestamp: commitTimestamp, EventType: EventTypeInsert,
+				ChangeSet: map[accesstypes.Field]DiffElem{
+					"Name":    {Old: "", New: "apple"},
+					"Count":   {Old: (*int64)(nil), New: &count},
+					"Ratings": {Old: []int64(nil), New: []int64{1, 2}},
+					"Data":    {Old: []byte(nil), New: []byte{1, 2}},
+				},
+			},
+		},
+		{
+			name: "update",
+			record: &ChangeStreamRecord{
+				CommitTimestamp: commitTimestamp, TableName: "Fruits", ModType: ModTypeUpdate,
+				Mods: []ChangeStreamMod{{
+					Keys:      []byte(`{"Region":"west","Id":"7"}`),
+					OldValues: []byte(`{"Name":"apple","Count":null}`),
+					NewValues: []byte(`{"Name":"pear","Count":"3"}`),
+				}},
+			},
+			want: &StreamChange{
+				TableName: "Fruits", KeySet: keySet, CommitTimestamp: commitTimestamp, EventType: EventTypeUpdate,
+				ChangeSet: map[accesstypes.Field]DiffElem{
+					"Name":  {Old: "apple", New: "pear"},
+					"Count": {Old: (*int64)(nil), New: &count},
+				},
+			},
+		},
+		{
+			name: "delete",
+			record: &ChangeStreamRecord{
+				CommitTimestamp: commitTimestamp, TableName: "Fruits", ModType: ModTypeDelete,
+				Mods: []ChangeStreamMod{{
+					Keys:      []byte(`{"Region":"west","Id":"7"}`),
+					OldValues: []byte(`{"Name":"pear","Count":null,"Ratings":[]}`),
+				}},
+			},
+			want: &StreamChange{
+				TableName: "Fruits", KeySet: keySet, CommitTimestamp: commitTimestamp, EventType: EventTypeDelete,
+				ChangeSet: map[accesstypes.Field]DiffElem{
+					"Region":  {Old: "west"},
+					"ID":      {Old: int64(7)},
+					"Name":    {Old: "pear"},
+					"Ratings": {Old: []int64{}},
+				},
+			},
+		},
+		{
+			name: "invalid value",
+			record: &ChangeStreamRecord{
+				TableName: "Fruits", ModType: ModTypeInsert,
+				Mods: []ChangeStreamMod{{Keys: []byte(`{"Region":"west","Id":"seven"}`)}},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported mod type",
+			record:  &ChangeStreamRecord{TableName: "Fruits", ModType: "TRUNCATE"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Records of other tables are skipped
+			reader := fakeChangeStreamReader{{TableName: "Vegetables", ModType: ModTypeInsert}, tt.record}
+			rowStructs := map[accesstypes.Resource]RowStruct{"Fruits": NewRowStruct(Fruit{})}
+
+			var got []*StreamChange
+			err := NewSpannerPatcher().ConsumeChangeStream(context.Background(), reader, rowStructs, func(_ context.Context, change *StreamChange) error {
+				got = append(got, change)
+
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SpannerPatcher.ConsumeChangeStream() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if len(got) != 1 {
+				t.Fatalf("SpannerPatcher.ConsumeChangeStream() changes = %d, want 1", len(got))
+			}
+			tt.want.RowStruct = rowStructs["Fruits"]
+			if tt.want.EventType != EventTypeDelete {
+				tt.want.newValues = make(map[accesstypes.Field]any, len(tt.want.ChangeSet))
+				for field, elem := range tt.want.ChangeSet {
+					tt.want.newValues[field] = elem.New
+				}
+			}
+			if !reflect.DeepEqual(got[0], tt.want) {
+				t.Errorf("SpannerPatcher.ConsumeChangeStream() = (%+v),  want (%+v)", got[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamChange_Mutation(t *testing.T) {
+	t.Parallel()
+
+	keySet := resource.NewKeySet("ID", int64(7))
+	change := &StreamChange{
+		TableName: "Fruits", KeySet: keySet, EventType: EventTypeUpdate,
+		ChangeSet: map[accesstypes.Field]DiffElem{"Name": {Old: "apple", New: "pear"}},
+	}
+
+	got := change.Mutation()
+	if got.Operation != OperationUpdate {
+		t.Errorf("StreamChange.Mutation().Operation = (%v),  want (%v)", got.Operation, OperationUpdate)
+	}
+	if got.PatchSet.KeySet().RowID() != keySet.RowID() {
+		t.Errorf("StreamChange.Mutation().PatchSet.KeySet() = (%v),  want (%v)", got.PatchSet.KeySet(), keySet)
+	}
+	if want := map[accesstypes.Field]any{"Name": "pear"}; !reflect.DeepEqual(got.PatchSet.Data(), want) {
+		t.Errorf("StreamChange.Mutation().PatchSet.Data() = (%v),  want (%v)", got.PatchSet.Data(), want)
+	}
+}
+
+func TestStreamChange_Mutation_audit(t *testing.T) {
+	t.Parallel()
+
+	type Account struct {
+		ID       int64  `spanner:"Id"`
+		Name     string `spanner:"Name"`
+		Password string `spanner:"Password" audit:"redact"`
+		Pin      int64  `spanner:"Pin"      audit:"hash"`
+		Token    string `spanner:"Token"    audit:"omit"`
+	}
+
+	record := &ChangeStreamRecord{
+		TableName: "Accounts", ModType: ModTypeUpdate,
+		Mods: []ChangeStreamMod{{
+			Keys:      []byte(`{"Id":"7"}`),
+			OldValues: []byte(`{"Name":"ann","Password":"old","Pin":"1234","Token":"abc"}`),
+			NewValues: []byte(`{"Name":"bob","Password":"new","Pin":"5678","Token":"def"}`),
+		}},
+	}
+
+	changes, err := NewSpannerPatcher().WithAuditHashKey([]byte("audit key")).StreamChanges(record, NewRowStruct(Account{}))
+	if err != nil {
+		t.Fatalf("SpannerPatcher.StreamChanges() error = %v", err)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("SpannerPatcher.StreamChanges() changes = %d, want 1", len(changes))
+	}
+
+	// The ChangeSet is masked, but the mutation writes the values of the change
+	if got := changes[0].ChangeSet["Password"]; got.New != redactedValue {
+		t.Errorf("StreamChange.ChangeSet[Password] = (%v),  want (%v)", got, redactedValue)
+	}
+	if _, ok := changes[0].ChangeSet["Token"]; ok {
+		t.Errorf("StreamChange.ChangeSet[Token] is recorded, want omitted")
+	}
+
+	got := changes[0].Mutation().PatchSet.Data()
+	want := map[accesstypes.Field]any{"Name": "bob", "Password": "new", "Pin": int64(5678), "Token": "def"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("StreamChange.Mutation().PatchSet.Data() = (%v),  want (%v)", got, want)
+	}
+}
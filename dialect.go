@@ -0,0 +1,133 @@
+package patcher
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Dialect describes the SQL syntax differences between database backends
+type Dialect interface {
+	// Name returns the name of the database backend
+	Name() string
+
+	// QuoteIdentifier quotes a table or column name
+	QuoteIdentifier(identifier string) string
+
+	// Placeholder returns the placeholder for the named parameter found at position in the statement, counting from 1
+	Placeholder(name string, position int) string
+
+	// BindArgs converts params, in the order they were placed in the statement, into the arguments expected by the driver
+	BindArgs(params []Param) []any
+
+	// Upsert returns a statement inserting values into columns of table, or updating the
+	// non-key columns when a row with the same keyColumns already exists.
+	// table, columns and keyColumns are expected to be quoted already.
+	Upsert(table string, columns, values, keyColumns []string) string
+
+	// ForUpdate returns the clause appended to a SELECT statement to lock the selected rows
+	ForUpdate() string
+
+	// CommitTimestamp returns the SQL expression for the commit timestamp of the current transaction
+	CommitTimestamp() string
+}
+
+// Param is a named query parameter
+type Param struct {
+	Name  string
+	Value any
+}
+
+// SpannerDialect is the Dialect for Google Cloud Spanner (GoogleSQL)
+type SpannerDialect struct{}
+
+func (SpannerDialect) Name() string {
+	return "spanner"
+}
+
+func (SpannerDialect) QuoteIdentifier(identifier string) string {
+	return identifier
+}
+
+func (SpannerDialect) Placeholder(name string, _ int) string {
+	return "@" + name
+}
+
+// BindArgs returns a single map[string]any suitable for spanner.Statement.Params
+func (SpannerDialect) BindArgs(params []Param) []any {
+	return []any{paramMap(params)}
+}
+
+func (SpannerDialect) Upsert(table string, columns, values, _ []string) string {
+	return fmt.Sprintf("INSERT OR UPDATE INTO %s (%s) VALUES (%s)", table, strings.Join(columns, ", "), strings.Join(values, ", "))
+}
+
+func (SpannerDialect) ForUpdate() string {
+	return ""
+}
+
+func (SpannerDialect) CommitTimestamp() string {
+	return "PENDING_COMMIT_TIMESTAMP()"
+}
+
+// PostgresDialect is the Dialect for PostgreSQL using the pgx driver
+type PostgresDialect struct{}
+
+func (PostgresDialect) Name() string {
+	return "postgres"
+}
+
+func (PostgresDialect) QuoteIdentifier(identifier string) string {
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
+
+func (PostgresDialect) Placeholder(name string, _ int) string {
+	return "@" + name
+}
+
+// BindArgs returns a single pgx.NamedArgs
+func (PostgresDialect) BindArgs(params []Param) []any {
+	return []any{pgx.NamedArgs(paramMap(params))}
+}
+
+func (PostgresDialect) Upsert(table string, columns, values, keyColumns []string) string {
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) %s",
+		table, strings.Join(columns, ", "), strings.Join(values, ", "), strings.Join(keyColumns, ", "), onConflict(columns, keyColumns),
+	)
+}
+
+func (PostgresDialect) ForUpdate() string {
+	return "FOR UPDATE"
+}
+
+func (PostgresDialect) CommitTimestamp() string {
+	return "CURRENT_TIMESTAMP"
+}
+
+// onConflict returns the ON CONFLICT action updating every column not in keyColumns
+func onConflict(columns, keyColumns []string) string {
+	set := make([]string, 0, len(columns))
+	for _, column := range columns {
+		if slices.Contains(keyColumns, column) {
+			continue
+		}
+		set = append(set, fmt.Sprintf("%s = EXCLUDED.%s", column, column))
+	}
+
+	if len(set) == 0 {
+		return "DO NOTHING"
+	}
+
+	return "DO UPDATE SET " + strings.Join(set, ", ")
+}
+
+func paramMap(params []Param) map[string]any {
+	m := make(map[string]any, len(params))
+	for _, param := range params {
+		m[param.Name] = param.Value
+	}
+
+	return m
+}
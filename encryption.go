@@ -0,0 +1,239 @@
+package patcher
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"github.com/cccteam/ccc/accesstypes"
+	"github.com/go-playground/errors/v5"
+)
+
+// KeyProvider protects the data keys encrypting ChangeSets with a key encryption key, e.g. held in a KMS.
+// A new data key is generated for each ChangeSet, and is stored wrapped alongside it.
+type KeyProvider interface {
+	// WrapKey encrypts dataKey, returning the ID of the key encryption key used
+	WrapKey(ctx context.Context, dataKey []byte) (keyID string, wrappedKey []byte, err error)
+
+	// UnwrapKey decrypts a data key wrapped by WrapKey with the key encryption key identified by keyID
+	UnwrapKey(ctx context.Context, keyID string, wrappedKey []byte) ([]byte, error)
+}
+
+var _ KeyProvider = (*StaticKeyProvider)(nil)
+
+// StaticKeyProvider wraps data keys with AES-GCM using key encryption keys held in memory
+type StaticKeyProvider struct {
+	keyID string
+	keys  map[string]cipher.AEAD
+}
+
+// NewStaticKeyProvider returns a provider wrapping data keys with the key identified by keyID. The other
+// keys are only used to unwrap data keys, so keys can be rotated. Keys must be 16, 24 or 32 bytes long.
+func NewStaticKeyProvider(keyID string, keys map[string][]byte) (*StaticKeyProvider, error) {
+	if _, ok := keys[keyID]; !ok {
+		return nil, errors.Newf("key %s not found in keys", keyID)
+	}
+
+	p := &StaticKeyProvider{keyID: keyID, keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "key %s", id)
+		}
+		p.keys[id] = aead
+	}
+
+	return p, nil
+}
+
+func (p *StaticKeyProvider) WrapKey(_ context.Context, dataKey []byte) (string, []byte, error) {
+	wrappedKey, err := seal(p.keys[p.keyID], dataKey, []byte(p.keyID))
+	if err != nil {
+		return "", nil, err
+	}
+
+	return p.keyID, wrappedKey, nil
+}
+
+func (p *StaticKeyProvider) UnwrapKey(_ context.Context, keyID string, wrappedKey []byte) ([]byte, error) {
+	aead, ok := p.keys[keyID]
+	if !ok {
+		return nil, errors.Newf("key %s not found", keyID)
+	}
+
+	return open(aead, wrappedKey, []byte(keyID))
+}
+
+// changeSetEncryption encrypts the ChangeSets of data change events of tables, or of all tables when tables is empty
+type changeSetEncryption struct {
+	keyProvider KeyProvider
+	tables      []accesstypes.Resource
+}
+
+func (e *changeSetEncryption) encrypts(tableName accesstypes.Resource) bool {
+	return len(e.tables) == 0 || slices.Contains(e.tables, tableName)
+}
+
+// envelopeField is the only field of an encrypted ChangeSet, which cannot be mistaken for a struct field name
+const envelopeField = "$envelope"
+
+// changeSetEnvelope is an encrypted ChangeSet, with the data key encrypting it
+type changeSetEnvelope struct {
+	KeyID      string `json:"keyId"`
+	WrappedKey []byte `json:"wrappedKey"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// WithChangeSetEncryption encrypts the ChangeSets of the data change events of tableNames, or of all tables when none are given,
+// with a data key protected by keyProvider. The read APIs of the history decrypt them, and DecryptDataChangeEvent decrypts the
+// events passed to sinks. The rest of the event is not encrypted, so events can still be filtered by table, row and source.
+func (p *SpannerPatcher) WithChangeSetEncryption(keyProvider KeyProvider, tableNames ...accesstypes.Resource) *SpannerPatcher {
+	p.encryption = &changeSetEncryption{keyProvider: keyProvider, tables: tableNames}
+
+	return p
+}
+
+// DecryptDataChangeEvent decrypts the ChangeSet of event when it is encrypted
+func (p *SpannerPatcher) DecryptDataChangeEvent(ctx context.Context, event *DataChangeEvent) error {
+	envelope, ok, err := parseEnvelope(event.ChangeSet)
+	if err != nil || !ok {
+		return err
+	}
+
+	if p.encryption == nil {
+		return errors.Newf("ChangeSet of %s (%s) is encrypted, but no KeyProvider is set", event.TableName, event.RowID)
+	}
+
+	dataKey, err := p.encryption.keyProvider.UnwrapKey(ctx, envelope.KeyID, envelope.WrappedKey)
+	if err != nil {
+		return errors.Wrap(err, "KeyProvider.UnwrapKey()")
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+
+	changeSet, err := open(aead, envelope.Ciphertext, changeSetAAD(event))
+	if err != nil {
+		return errors.Wrapf(err, "ChangeSet of %s (%s)", event.TableName, event.RowID)
+	}
+	event.ChangeSet = string(changeSet)
+
+	return nil
+}
+
+// decryptDataChangeEvents decrypts the ChangeSets of events
+func (p *SpannerPatcher) decryptDataChangeEvents(ctx context.Context, events []*DataChangeEvent) error {
+	for _, event := range events {
+		if err := p.DecryptDataChangeEvent(ctx, event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encryptDataChangeEvent encrypts the ChangeSet of event when encryption is enabled for its table
+func (p *SpannerPatcher) encryptDataChangeEvent(ctx context.Context, event *DataChangeEvent) error {
+	if p.encryption == nil || !p.encryption.encrypts(event.TableName) {
+		return nil
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return errors.Wrap(err, "rand.Read()")
+	}
+
+	keyID, wrappedKey, err := p.encryption.keyProvider.WrapKey(ctx, dataKey)
+	if err != nil {
+		return errors.Wrap(err, "KeyProvider.WrapKey()")
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+
+	ciphertext, err := seal(aead, []byte(event.ChangeSet), changeSetAAD(event))
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(map[string]changeSetEnvelope{
+		envelopeField: {KeyID: keyID, WrappedKey: wrappedKey, Ciphertext: ciphertext},
+	})
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal()")
+	}
+	event.ChangeSet = string(b)
+
+	return nil
+}
+
+// parseEnvelope returns the envelope of changeSet, and false when it is not encrypted
+func parseEnvelope(changeSet string) (*changeSetEnvelope, bool, error) {
+	if !strings.HasPrefix(changeSet, `{"`+envelopeField+`"`) {
+		return nil, false, nil
+	}
+
+	var envelope map[string]*changeSetEnvelope
+	if err := json.Unmarshal([]byte(changeSet), &envelope); err != nil {
+		return nil, false, errors.Wrap(err, "json.Unmarshal()")
+	}
+
+	e, ok := envelope[envelopeField]
+	if !ok || e == nil {
+		return nil, false, errors.New("invalid ChangeSet envelope")
+	}
+
+	return e, true, nil
+}
+
+// changeSetAAD binds an encrypted ChangeSet to its row, so it cannot be moved to the event of another row
+func changeSetAAD(event *DataChangeEvent) []byte {
+	return []byte(string(event.TableName) + "\x00" + event.RowID)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "aes.NewCipher()")
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "cipher.NewGCM()")
+	}
+
+	return aead, nil
+}
+
+// seal encrypts plaintext with a random nonce, which is prepended to the ciphertext
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "rand.Read()")
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open decrypts ciphertext sealed by seal
+func open(aead cipher.AEAD, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, errors.Wrap(err, "cipher.AEAD.Open()")
+	}
+
+	return plaintext, nil
+}
@@ -0,0 +1,129 @@
+package patcher
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSpannerPatcher_encryptDataChangeEvent(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	oldKey, currentKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	changeSet := `{"Name":{"Old":"apple","New":"pear"}}`
+
+	oldProvider, err := NewStaticKeyProvider("old", map[string][]byte{"old": oldKey})
+	if err != nil {
+		t.Fatalf("NewStaticKeyProvider() error = %v", err)
+	}
+	provider, err := NewStaticKeyProvider("current", map[string][]byte{"old": oldKey, "current": currentKey})
+	if err != nil {
+		t.Fatalf("NewStaticKeyProvider() error = %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		writer        *SpannerPatcher
+		reader        *SpannerPatcher
+		event         *DataChangeEvent
+		moveTo        string
+		wantEncrypted bool
+		wantErr       bool
+	}{
+		{
+			name:          "encrypted",
+			writer:        NewSpannerPatcher().WithChangeSetEncryption(provider, "Fruits"),
+			reader:        NewSpannerPatcher().WithChangeSetEncryption(provider),
+			event:         &DataChangeEvent{TableName: "Fruits", RowID: "1", ChangeSet: changeSet},
+			wantEncrypted: true,
+		},
+		{
+			name:          "rotated key",
+			writer:        NewSpannerPatcher().WithChangeSetEncryption(oldProvider),
+			reader:        NewSpannerPatcher().WithChangeSetEncryption(provider),
+			event:         &DataChangeEvent{TableName: "Fruits", RowID: "1", ChangeSet: changeSet},
+			wantEncrypted: true,
+		},
+		{
+			name:   "table not encrypted",
+			writer: NewSpannerPatcher().WithChangeSetEncryption(provider, "Vegetables"),
+			reader: NewSpannerPatcher(),
+			event:  &DataChangeEvent{TableName: "Fruits", RowID: "1", ChangeSet: changeSet},
+		},
+		{
+			name:          "moved to another row",
+			writer:        NewSpannerPatcher().WithChangeSetEncryption(provider),
+			reader:        NewSpannerPatcher().WithChangeSetEncryption(provider),
+			event:         &DataChangeEvent{TableName: "Fruits", RowID: "1", ChangeSet: changeSet},
+			moveTo:        "2",
+			wantEncrypted: true,
+			wantErr:       true,
+		},
+		{
+			name:          "no KeyProvider",
+			writer:        NewSpannerPatcher().WithChangeSetEncryption(provider),
+			reader:        NewSpannerPatcher(),
+			event:         &DataChangeEvent{TableName: "Fruits", RowID: "1", ChangeSet: changeSet},
+			wantEncrypted: true,
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tt.writer.encryptDataChangeEvent(ctx, tt.event); err != nil {
+				t.Fatalf("SpannerPatcher.encryptDataChangeEvent() error = %v", err)
+			}
+			if encrypted := strings.HasPrefix(tt.event.ChangeSet, `{"$envelope":`); encrypted != tt.wantEncrypted {
+				t.Fatalf("SpannerPatcher.encryptDataChangeEvent() = (%v),  want encrypted (%v)", tt.event.ChangeSet, tt.wantEncrypted)
+			}
+			if tt.wantEncrypted && strings.Contains(tt.event.ChangeSet, "apple") {
+				t.Fatalf("SpannerPatcher.encryptDataChangeEvent() = (%v),  want no plaintext", tt.event.ChangeSet)
+			}
+
+			if tt.moveTo != "" {
+				tt.event.RowID = tt.moveTo
+			}
+
+			err := tt.reader.DecryptDataChangeEvent(ctx, tt.event)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SpannerPatcher.DecryptDataChangeEvent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.event.ChangeSet != changeSet {
+				t.Errorf("SpannerPatcher.DecryptDataChangeEvent() = (%v),  want (%v)", tt.event.ChangeSet, changeSet)
+			}
+		})
+	}
+}
+
+func TestNewStaticKeyProvider(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		keyID   string
+		keys    map[string][]byte
+		wantErr bool
+	}{
+		{name: "valid", keyID: "a", keys: map[string][]byte{"a": make([]byte, 32), "b": make([]byte, 16)}},
+		{name: "missing key", keyID: "c", keys: map[string][]byte{"a": make([]byte, 32)}, wantErr: true},
+		{name: "invalid key size", keyID: "a", keys: map[string][]byte{"a": make([]byte, 10)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := NewStaticKeyProvider(tt.keyID, tt.keys); (err != nil) != tt.wantErr {
+				t.Errorf("NewStaticKeyProvider() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package patcher
+
+import (
+	"slices"
+	"strings"
+)
+
+// DataChangeEventColumn is an optional column of the change tracking table. The baseline table has the columns
+//
+//	TableName STRING(MAX) NOT NULL,
+//	RowId STRING(MAX) NOT NULL,
+//	EventTime TIMESTAMP NOT NULL OPTIONS (allow_commit_timestamp=true),
+//	EventSource STRING(MAX) NOT NULL,
+//	ChangeSet JSON NOT NULL,
+//
+// which are always written and read. An optional column is only written and read once it is enabled
+// with WithDataChangeEventColumns, so it is added to the table before it is enabled.
+type DataChangeEventColumn string
+
+const (
+	// EventTypeColumn records the EventType of the event. The type of the events recorded before
+	// it was enabled is inferred from their ChangeSet, see DataChangeEvent.Type.
+	//	EventType STRING(MAX)
+	EventTypeColumn DataChangeEventColumn = "EventType"
+
+	// ChangeSetVersionColumn records the ChangeSetVersion of the event
+	//	ChangeSetVersion INT64
+	ChangeSetVersionColumn DataChangeEventColumn = "ChangeSetVersion"
+
+	// CorrelationIDColumn records the CorrelationID of the event
+	//	CorrelationId STRING(MAX)
+	CorrelationIDColumn DataChangeEventColumn = "CorrelationId"
+
+	// ReasonColumn records the Reason of the event
+	//	Reason STRING(MAX)
+	ReasonColumn DataChangeEventColumn = "Reason"
+
+	// RevertedEventTimeColumn records the RevertedEventTime of the events of Revert
+	//	RevertedEventTime TIMESTAMP
+	RevertedEventTimeColumn DataChangeEventColumn = "RevertedEventTime"
+)
+
+// optionalEventColumns are the optional columns in the order they are selected
+var optionalEventColumns = []DataChangeEventColumn{
+	EventTypeColumn, ChangeSetVersionColumn, CorrelationIDColumn, ReasonColumn, RevertedEventTimeColumn,
+}
+
+// baselineEventColumns are the columns of the baseline change tracking table
+const baselineEventColumns = "TableName, RowId, EventTime, EventSource, ChangeSet"
+
+// eventColumns are the optional columns enabled for a change tracking table
+type eventColumns []DataChangeEventColumn
+
+// writes reports whether the column tag is written, which is when it is a baseline column or an enabled optional column
+func (c eventColumns) writes(tag string) bool {
+	column := DataChangeEventColumn(tag)
+
+	return !slices.Contains(optionalEventColumns, column) || slices.Contains(c, column)
+}
+
+// selectList returns the columns selected when reading data change events. Columns added after the change
+// tracking table was created are null for the events recorded before them, so they are read as zero values.
+func (c eventColumns) selectList() string {
+	columns := []string{baselineEventColumns}
+	for _, column := range optionalEventColumns {
+		if !slices.Contains(c, column) {
+			continue
+		}
+
+		switch column {
+		case EventTypeColumn:
+			columns = append(columns, "COALESCE(EventType, '') AS EventType")
+		case ChangeSetVersionColumn:
+			columns = append(columns, "COALESCE(ChangeSetVersion, 0) AS ChangeSetVersion")
+		default:
+			columns = append(columns, string(column))
+		}
+	}
+
+	return strings.Join(columns, ", ")
+}
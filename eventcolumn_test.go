@@ -0,0 +1,34 @@
+package patcher
+
+import "testing"
+
+func Test_eventColumns_selectList(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		columns eventColumns
+		want    string
+	}{
+		{
+			name: "baseline",
+			want: "TableName, RowId, EventTime, EventSource, ChangeSet",
+		},
+		{
+			name:    "optional columns in select order",
+			columns: eventColumns{RevertedEventTimeColumn, ChangeSetVersionColumn, EventTypeColumn},
+			want: "TableName, RowId, EventTime, EventSource, ChangeSet, COALESCE(EventType, '') AS EventType, " +
+				"COALESCE(ChangeSetVersion, 0) AS ChangeSetVersion, RevertedEventTime",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := tt.columns.selectList(); got != tt.want {
+				t.Errorf("eventColumns.selectList() = (%v),  want (%v)", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,21 +2,124 @@ package patcher
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"regexp"
+	"strings"
 
 	"github.com/cccteam/session/sessioninfo"
+	"github.com/go-playground/errors/v5"
 )
 
+// EventSource identifies who, or what, made a data change. It is stored in the EventSource
+// column of a DataChangeEvent as JSON, use String to get the value passed to the *WithDataChangeEvent methods.
+type EventSource struct {
+	UserID       string `json:"userId,omitempty"`
+	Username     string `json:"username,omitempty"`
+	ProcessName  string `json:"processName,omitempty"`
+	RequestID    string `json:"requestId,omitempty"`
+	IP           string `json:"ip,omitempty"`
+	Impersonator string `json:"impersonator,omitempty"`
+}
+
+// NewUserEventSource returns the EventSource for the user of the session in ctx
+func NewUserEventSource(ctx context.Context) *EventSource {
+	user := sessioninfo.FromCtx(ctx)
+
+	return &EventSource{UserID: user.ID.String(), Username: user.Username}
+}
+
+// NewProcessEventSource returns the EventSource for a process
+func NewProcessEventSource(processName string) *EventSource {
+	return &EventSource{ProcessName: processName}
+}
+
+// NewUserProcessEventSource returns the EventSource for a process run by the user of the session in ctx
+func NewUserProcessEventSource(ctx context.Context, processName string) *EventSource {
+	s := NewUserEventSource(ctx)
+	s.ProcessName = processName
+
+	return s
+}
+
+func (s *EventSource) WithRequestID(requestID string) *EventSource {
+	s.RequestID = requestID
+
+	return s
+}
+
+func (s *EventSource) WithIP(ip string) *EventSource {
+	s.IP = ip
+
+	return s
+}
+
+// WithImpersonator records the user acting on behalf of the user of the EventSource
+func (s *EventSource) WithImpersonator(impersonator string) *EventSource {
+	s.Impersonator = impersonator
+
+	return s
+}
+
+// String returns the EventSource encoded as JSON
+func (s *EventSource) String() string {
+	b, err := json.Marshal(s)
+	if err != nil {
+		// EventSource only has string fields, which always marshal
+		panic(err)
+	}
+
+	return string(b)
+}
+
+// legacyEventSource matches the strings formatted by UserEvent and UserProcessEvent. The username is matched lazily,
+// so the ID is the first parenthesized part which is followed by the end or by the process name, either of which may
+// contain parentheses.
+var legacyEventSource = regexp.MustCompile(`^(.*?) \(([^()]*)\)(?:: Process (.*))?$`)
+
+// ParseEventSource parses the EventSource column of a DataChangeEvent, which is either JSON
+// written from an EventSource, or a string formatted by UserEvent, ProcessEvent or UserProcessEvent.
+func ParseEventSource(eventSource string) (*EventSource, error) {
+	if strings.HasPrefix(eventSource, "{") {
+		s := &EventSource{}
+		if err := json.Unmarshal([]byte(eventSource), s); err != nil {
+			return nil, errors.Wrap(err, "json.Unmarshal()")
+		}
+
+		return s, nil
+	}
+
+	// The name of a process may contain parentheses, so it is not matched as a user
+	if processName, ok := strings.CutPrefix(eventSource, "Process "); ok {
+		return &EventSource{ProcessName: processName}, nil
+	}
+
+	if match := legacyEventSource.FindStringSubmatch(eventSource); match != nil {
+		return &EventSource{Username: match[1], UserID: match[2], ProcessName: match[3]}, nil
+	}
+
+	return nil, errors.Newf("unrecognized event source %q", eventSource)
+}
+
+// UserEvent returns the event source of the user of the session in ctx, formatted as "username (id)".
+//
+// Deprecated: Use NewUserEventSource.
 func UserEvent(ctx context.Context) string {
 	user := sessioninfo.FromCtx(ctx)
 
 	return fmt.Sprintf("%s (%s)", user.Username, user.ID)
 }
 
+// ProcessEvent returns the event source of a process, formatted as "Process name".
+//
+// Deprecated: Use NewProcessEventSource.
 func ProcessEvent(processName string) string {
 	return fmt.Sprintf("Process %s", processName)
 }
 
+// UserProcessEvent returns the event source of a process run by the user of the session in ctx.
+//
+// Deprecated: Use NewUserProcessEventSource.
 func UserProcessEvent(ctx context.Context, processName string) string {
 	return fmt.Sprintf("%s: %s", UserEvent(ctx), ProcessEvent(processName))
 }
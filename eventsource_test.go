@@ -0,0 +1,54 @@
+package patcher
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseEventSource(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		eventSource string
+		want        *EventSource
+		wantErr     bool
+	}{
+		{
+			name:        "json",
+			eventSource: (&EventSource{UserID: "1", Username: "alice", ProcessName: "sync"}).WithRequestID("r1").WithImpersonator("bob").String(),
+			want:        &EventSource{UserID: "1", Username: "alice", ProcessName: "sync", RequestID: "r1", Impersonator: "bob"},
+		},
+		{name: "legacy user", eventSource: "alice (1)", want: &EventSource{UserID: "1", Username: "alice"}},
+		{name: "legacy process", eventSource: "Process sync", want: &EventSource{ProcessName: "sync"}},
+		{name: "legacy user process", eventSource: "alice (1): Process sync", want: &EventSource{UserID: "1", Username: "alice", ProcessName: "sync"}},
+		{name: "legacy process with parentheses", eventSource: "Process sync (nightly)", want: &EventSource{ProcessName: "sync (nightly)"}},
+		{name: "legacy user with parentheses", eventSource: "alice (admin) (1)", want: &EventSource{UserID: "1", Username: "alice (admin)"}},
+		{
+			name:        "legacy user process with parentheses",
+			eventSource: "alice (admin) (1): Process sync (nightly)",
+			want:        &EventSource{UserID: "1", Username: "alice (admin)", ProcessName: "sync (nightly)"},
+		},
+		{
+			name:        "legacy user process with parentheses in the process name",
+			eventSource: "alice (1): Process sync (nightly)",
+			want:        &EventSource{UserID: "1", Username: "alice", ProcessName: "sync (nightly)"},
+		},
+		{name: "unrecognized", eventSource: "alice", wantErr: true},
+		{name: "invalid json", eventSource: "{", wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := ParseEventSource(tt.eventSource)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseEventSource() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseEventSource() = (%v),  want (%v)", got, tt.want)
+			}
+		})
+	}
+}
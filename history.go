@@ -0,0 +1,282 @@
+package patcher
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/cccteam/ccc/accesstypes"
+	"github.com/cccteam/ccc/resource"
+	"github.com/cccteam/httpio"
+	"github.com/cccteam/spxscan"
+	"github.com/go-playground/errors/v5"
+)
+
+// RowAsOf reconstructs the row identified by keySet as it was at asOf, by replaying the ChangeSets of its data change events.
+// The returned value is a pointer to the type of row. Fields which were never written by a data change event have their zero value.
+func (p *SpannerPatcher) RowAsOf(
+	ctx context.Context, s *spanner.Client, tableName accesstypes.Resource, keySet resource.KeySet, row RowStruct, asOf time.Time,
+) (any, error) {
+	stmt := spanner.NewStatement(fmt.Sprintf(`
+			SELECT
+				%s
+			FROM %s
+			WHERE TableName = @tableName AND RowId = @rowId AND EventTime <= @asOf
+			ORDER BY EventTime`, p.eventColumns.selectList(), p.changeTrackingTable,
+	))
+	stmt.Params["tableName"] = string(tableName)
+	stmt.Params["rowId"] = keySet.RowID()
+	stmt.Params["asOf"] = asOf
+
+	var events []*DataChangeEvent
+	if err := spxscan.Select(ctx, s.Single(), &events, stmt); err != nil {
+		return nil, errors.Wrap(err, "spxscan.Select()")
+	}
+
+	if err := p.decryptDataChangeEvents(ctx, events); err != nil {
+		return nil, err
+	}
+
+	v, err := replay(events, keySet, row)
+	if err != nil {
+		return nil, err
+	}
+
+	if v == nil {
+		return nil, httpio.NewNotFoundMessagef("%s (%s) did not exist at %s", tableName, keySet.String(), asOf.Format(time.RFC3339))
+	}
+
+	return v, nil
+}
+
+// replay applies the ChangeSets of events, in order, to a new row, returning nil when the row does not exist after the last event.
+// Key fields are set from keySet, since they are not recorded in the ChangeSet of an insert.
+func replay(events []*DataChangeEvent, keySet resource.KeySet, row RowStruct) (any, error) {
+	rowType := rowStructType(row)
+
+	var v reflect.Value
+	for _, event := range events {
+		changeSet, err := parseChangeSet(event.ChangeSet)
+		if err != nil {
+			return nil, err
+		}
+
+		eventType, err := event.Type(keySet)
+		if err != nil {
+			return nil, err
+		}
+
+		if eventType == EventTypeDelete {
+			v = reflect.Value{}
+
+			continue
+		}
+
+		if !v.IsValid() {
+			v = reflect.New(rowType)
+			for _, part := range keySet.Parts() {
+				field := v.Elem().FieldByName(string(part.Key))
+				if !field.IsValid() {
+					return nil, errors.Newf("field %s not found in struct", part.Key)
+				}
+				value := reflect.ValueOf(part.Value)
+				if !value.IsValid() || !value.Type().AssignableTo(field.Type()) {
+					return nil, errors.Newf("key %s must be of type %s", part.Key, field.Type())
+				}
+				field.Set(value)
+			}
+		}
+
+		for field, elem := range changeSet {
+			if isMasked(rowType, field) {
+				continue
+			}
+
+			value, err := decodeField(rowType, field, elem.New)
+			if err != nil {
+				return nil, err
+			}
+			v.Elem().FieldByName(string(field)).Set(reflect.ValueOf(value))
+		}
+	}
+
+	if !v.IsValid() {
+		return nil, nil
+	}
+
+	return v.Interface(), nil
+}
+
+// defaultChangesLimit is the number of changes returned by ListChanges when ChangeFilter.Limit is not set
+const defaultChangesLimit = 100
+
+// ChangeFilter selects the data change events returned by ListChanges. Fields left at their zero value do not filter.
+type ChangeFilter struct {
+	TableName   accesstypes.Resource
+	RowID       string
+	EventSource string
+
+	// CorrelationID can only filter when CorrelationIDColumn is enabled
+	CorrelationID string
+
+	// Since and Until select the events with Since <= EventTime < Until
+	Since time.Time
+	Until time.Time
+
+	// Limit is the maximum number of changes returned, defaulting to 100
+	Limit int
+
+	// Cursor continues the listing from the NextCursor of a previous ChangePage
+	Cursor string
+}
+
+// Change is a DataChangeEvent with its ChangeSet decoded. Since the RowStruct of the table is not known,
+// the values of Diff have their JSON types, use DecodeChangeSet to decode them into the types of the row.
+type Change struct {
+	DataChangeEvent
+	Diff map[accesstypes.Field]DiffElem
+}
+
+// ChangePage is a page of changes returned by ListChanges, most recent first
+type ChangePage struct {
+	Changes []*Change
+
+	// NextCursor is set to the ChangeFilter.Cursor of the next page, or empty when this is the last page
+	NextCursor string
+}
+
+// changeCursor identifies the last change returned in a ChangePage
+type changeCursor struct {
+	EventTime time.Time            `json:"t"`
+	TableName accesstypes.Resource `json:"n"`
+	RowID     string               `json:"r"`
+}
+
+// ListChanges returns the data change events selected by filter, most recent first, a page at a time.
+// A nil filter selects all events, 100 at a time.
+func (p *SpannerPatcher) ListChanges(ctx context.Context, s *spanner.Client, filter *ChangeFilter) (*ChangePage, error) {
+	stmt, limit, err := p.listChangesStatement(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []*DataChangeEvent
+	if err := spxscan.Select(ctx, s.Single(), &events, stmt); err != nil {
+		return nil, errors.Wrap(err, "spxscan.Select()")
+	}
+
+	if err := p.decryptDataChangeEvents(ctx, events); err != nil {
+		return nil, err
+	}
+
+	return changePage(events, limit)
+}
+
+// listChangesStatement returns the statement selecting a page of events for filter, reading one event past
+// the limit to find out if there is a next page. A nil filter selects all events.
+func (p *SpannerPatcher) listChangesStatement(filter *ChangeFilter) (spanner.Statement, int, error) {
+	if filter == nil {
+		filter = &ChangeFilter{}
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultChangesLimit
+	}
+
+	params := map[string]any{"limit": int64(limit + 1)}
+	conditions := []string{"TRUE"}
+	if filter.TableName != "" {
+		conditions = append(conditions, "TableName = @tableName")
+		params["tableName"] = string(filter.TableName)
+	}
+	if filter.RowID != "" {
+		conditions = append(conditions, "RowId = @rowId")
+		params["rowId"] = filter.RowID
+	}
+	if filter.EventSource != "" {
+		conditions = append(conditions, "EventSource = @eventSource")
+		params["eventSource"] = filter.EventSource
+	}
+	if filter.CorrelationID != "" {
+		if !slices.Contains(p.eventColumns, CorrelationIDColumn) {
+			return spanner.Statement{}, 0, httpio.NewBadRequestMessage("CorrelationID cannot filter changes, CorrelationIDColumn is not enabled")
+		}
+		conditions = append(conditions, "CorrelationId = @correlationId")
+		params["correlationId"] = filter.CorrelationID
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "EventTime >= @since")
+		params["since"] = filter.Since
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "EventTime < @until")
+		params["until"] = filter.Until
+	}
+	if filter.Cursor != "" {
+		cursor, err := parseChangeCursor(filter.Cursor)
+		if err != nil {
+			return spanner.Statement{}, 0, err
+		}
+		conditions = append(conditions, `(EventTime < @cursorTime
+				OR (EventTime = @cursorTime AND (TableName > @cursorTable OR (TableName = @cursorTable AND RowId > @cursorRow))))`)
+		params["cursorTime"] = cursor.EventTime
+		params["cursorTable"] = string(cursor.TableName)
+		params["cursorRow"] = cursor.RowID
+	}
+
+	return spanner.Statement{
+		SQL: fmt.Sprintf(`
+			SELECT
+				%s
+			FROM %s
+			WHERE %s
+			ORDER BY EventTime DESC, TableName, RowId
+			LIMIT @limit`, p.eventColumns.selectList(), p.changeTrackingTable, strings.Join(conditions, " AND "),
+		),
+		Params: params,
+	}, limit, nil
+}
+
+// changePage decodes up to limit events into a ChangePage, setting NextCursor when there are more events
+func changePage(events []*DataChangeEvent, limit int) (*ChangePage, error) {
+	page := &ChangePage{Changes: make([]*Change, 0, min(len(events), limit))}
+	for _, event := range events[:min(len(events), limit)] {
+		var diff map[accesstypes.Field]DiffElem
+		if err := json.Unmarshal([]byte(event.ChangeSet), &diff); err != nil {
+			return nil, errors.Wrap(err, "json.Unmarshal()")
+		}
+		page.Changes = append(page.Changes, &Change{DataChangeEvent: *event, Diff: diff})
+	}
+
+	if len(events) > limit {
+		last := events[limit-1]
+		cursor, err := json.Marshal(&changeCursor{EventTime: last.EventTime, TableName: last.TableName, RowID: last.RowID})
+		if err != nil {
+			return nil, errors.Wrap(err, "json.Marshal()")
+		}
+		page.NextCursor = base64.RawURLEncoding.EncodeToString(cursor)
+	}
+
+	return page, nil
+}
+
+func parseChangeCursor(s string) (*changeCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, httpio.NewBadRequestMessageWithError(err, "invalid cursor")
+	}
+
+	cursor := &changeCursor{}
+	if err := json.Unmarshal(b, cursor); err != nil {
+		return nil, httpio.NewBadRequestMessageWithError(err, "invalid cursor")
+	}
+
+	return cursor, nil
+}
@@ -0,0 +1,239 @@
+package patcher
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cccteam/ccc/resource"
+	"github.com/cccteam/httpio"
+	"github.com/georgysavva/scany/v2/sqlscan"
+)
+
+func Test_replay(t *testing.T) {
+	t.Parallel()
+
+	type Fruit struct {
+		ID    string  `spanner:"Id"`
+		Name  string  `spanner:"Name"`
+		Count int64   `spanner:"Count"`
+		Color *string `spanner:"Color"`
+	}
+
+	insert := &DataChangeEvent{ChangeSet: `{"Name":{"Old":"","New":"apple"},"Count":{"Old":0,"New":1},"Color":{"Old":null,"New":"red"}}`}
+	update := &DataChangeEvent{ChangeSet: `{"Count":{"Old":1,"New":2},"Color":{"Old":"red","New":null}}`}
+	del := &DataChangeEvent{ChangeSet: `{"ID":{"Old":"1","New":null},"Name":{"Old":"apple","New":null},"Count":{"Old":2,"New":null}}`}
+	red := "red"
+
+	tests := []struct {
+		name    string
+		events  []*DataChangeEvent
+		want    any
+		wantErr bool
+	}{
+		{name: "no events", events: nil, want: nil},
+		{name: "insert", events: []*DataChangeEvent{insert}, want: &Fruit{ID: "1", Name: "apple", Count: 1, Color: &red}},
+		{name: "update", events: []*DataChangeEvent{insert, update}, want: &Fruit{ID: "1", Name: "apple", Count: 2}},
+		{
+			name:   "update of a single field to null",
+			events: []*DataChangeEvent{insert, {ChangeSet: `{"Color":{"Old":"red","New":null}}`}},
+			want:   &Fruit{ID: "1", Name: "apple", Count: 1},
+		},
+		{name: "delete", events: []*DataChangeEvent{insert, update, del}, want: nil},
+		{name: "insert after delete", events: []*DataChangeEvent{insert, update, del, insert}, want: &Fruit{ID: "1", Name: "apple", Count: 1, Color: &red}},
+		{name: "invalid ChangeSet", events: []*DataChangeEvent{{ChangeSet: "{"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := replay(tt.events, resource.NewKeySet("ID", "1"), NewRowStruct(Fruit{}))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("replay() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.want == nil {
+				if got != nil {
+					t.Errorf("replay() = (%v),  want (nil)", got)
+				}
+
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("replay() = (%+v),  want (%+v)", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_changePage(t *testing.T) {
+	t.Parallel()
+
+	eventTime := time.Date(2032, 4, 23, 12, 2, 3, 4, time.UTC)
+	events := []*DataChangeEvent{
+		{TableName: "Fruits", RowID: "1", EventTime: eventTime, ChangeSet: `{"Name":{"Old":"apple","New":"banana"}}`},
+		{TableName: "Fruits", RowID: "2", EventTime: eventTime, ChangeSet: `{"Count":{"Old":1,"New":2}}`},
+		{TableName: "Fruits", RowID: "1", EventTime: eventTime.Add(-time.Hour), ChangeSet: `{}`},
+	}
+
+	page, err := changePage(events, 2)
+	if err != nil {
+		t.Fatalf("changePage() error = %v", err)
+	}
+	if len(page.Changes) != 2 {
+		t.Fatalf("changePage() len(Changes) = (%d),  want (%d)", len(page.Changes), 2)
+	}
+	if want := (DiffElem{Old: "apple", New: "banana"}); page.Changes[0].Diff["Name"] != want {
+		t.Errorf("changePage() Diff = (%v),  want (%v)", page.Changes[0].Diff["Name"], want)
+	}
+
+	cursor, err := parseChangeCursor(page.NextCursor)
+	if err != nil {
+		t.Fatalf("parseChangeCursor() error = %v", err)
+	}
+	if want := (&changeCursor{EventTime: eventTime, TableName: "Fruits", RowID: "2"}); !reflect.DeepEqual(cursor, want) {
+		t.Errorf("parseChangeCursor() = (%v),  want (%v)", cursor, want)
+	}
+
+	page, err = changePage(events[2:], 2)
+	if err != nil {
+		t.Fatalf("changePage() error = %v", err)
+	}
+	if page.NextCursor != "" {
+		t.Errorf("changePage() NextCursor = (%v),  want last page", page.NextCursor)
+	}
+
+	if _, err := parseChangeCursor("not a cursor"); !httpio.HasBadRequest(err) {
+		t.Errorf("parseChangeCursor() error = %v, want bad request", err)
+	}
+}
+
+func TestSpannerPatcher_listChangesStatement(t *testing.T) {
+	t.Parallel()
+
+	since := time.Date(2032, 4, 23, 0, 0, 0, 0, time.UTC)
+	stmt, limit, err := NewSpannerPatcher().listChangesStatement(&ChangeFilter{TableName: "Fruits", Since: since, Limit: 10})
+	if err != nil {
+		t.Fatalf("SpannerPatcher.listChangesStatement() error = %v", err)
+	}
+	if limit != 10 {
+		t.Errorf("SpannerPatcher.listChangesStatement() limit = (%d),  want (%d)", limit, 10)
+	}
+
+	want := map[string]any{"tableName": "Fruits", "since": since, "limit": int64(11)}
+	if !reflect.DeepEqual(stmt.Params, want) {
+		t.Errorf("SpannerPatcher.listChangesStatement() Params = (%v),  want (%v)", stmt.Params, want)
+	}
+	if !strings.Contains(stmt.SQL, "WHERE TRUE AND TableName = @tableName AND EventTime >= @since") {
+		t.Errorf("SpannerPatcher.listChangesStatement() SQL = (%v), missing filter conditions", stmt.SQL)
+	}
+}
+
+func TestSpannerPatcher_listChangesStatement_nilFilter(t *testing.T) {
+	t.Parallel()
+
+	stmt, limit, err := NewSpannerPatcher().listChangesStatement(nil)
+	if err != nil {
+		t.Fatalf("SpannerPatcher.listChangesStatement() error = %v", err)
+	}
+	if limit != defaultChangesLimit {
+		t.Errorf("SpannerPatcher.listChangesStatement() limit = (%d),  want (%d)", limit, defaultChangesLimit)
+	}
+
+	want := map[string]any{"limit": int64(defaultChangesLimit + 1)}
+	if !reflect.DeepEqual(stmt.Params, want) {
+		t.Errorf("SpannerPatcher.listChangesStatement() Params = (%v),  want (%v)", stmt.Params, want)
+	}
+	if !strings.Contains(stmt.SQL, "WHERE TRUE\n") {
+		t.Errorf("SpannerPatcher.listChangesStatement() SQL = (%v), want no filter conditions", stmt.SQL)
+	}
+}
+
+func TestSpannerPatcher_listChangesStatement_correlationID(t *testing.T) {
+	t.Parallel()
+
+	filter := &ChangeFilter{CorrelationID: "trace-1"}
+	if _, _, err := NewSpannerPatcher().listChangesStatement(filter); !httpio.HasBadRequest(err) {
+		t.Errorf("SpannerPatcher.listChangesStatement() error = %v, want bad request", err)
+	}
+
+	stmt, _, err := NewSpannerPatcher().WithDataChangeEventColumns(CorrelationIDColumn, ReasonColumn).listChangesStatement(filter)
+	if err != nil {
+		t.Fatalf("SpannerPatcher.listChangesStatement() error = %v", err)
+	}
+	if !strings.Contains(stmt.SQL, "ChangeSet, CorrelationId, Reason") || !strings.Contains(stmt.SQL, "CorrelationId = @correlationId") {
+		t.Errorf("SpannerPatcher.listChangesStatement() SQL = (%v), missing CorrelationId", stmt.SQL)
+	}
+}
+
+func TestSpannerPatcher_history_baselineTable(t *testing.T) {
+	t.Parallel()
+
+	type Fruit struct {
+		ID    string `db:"Id"`
+		Name  string `db:"Name"`
+		Count int64  `db:"Count"`
+	}
+
+	ctx := context.Background()
+
+	// The change tracking table has none of the optional columns
+	db := newSQLiteDB(t,
+		`CREATE TABLE Fruits (Id TEXT PRIMARY KEY, Name TEXT NOT NULL, Count INTEGER NOT NULL)`,
+		`CREATE TABLE DataChangeEvents (TableName TEXT, RowId TEXT, EventTime DATETIME, EventSource TEXT, ChangeSet TEXT)`,
+	)
+
+	writer := NewSQLitePatcher()
+	mutation := func(patchSet *resource.PatchSet) *Mutation {
+		patchSet.SetKey("ID", "1")
+
+		return &Mutation{TableName: "Fruits", RowStruct: NewRowStruct(Fruit{}), PatchSet: patchSet}
+	}
+
+	if err := writer.InsertWithDataChangeEvent(ctx, db, "test", mutation(resource.NewPatchSet().Set("Name", "apple").Set("Count", int64(1)))); err != nil {
+		t.Fatalf("SQLitePatcher.InsertWithDataChangeEvent() error = %v", err)
+	}
+	// EventTime has millisecond precision, so the events are ordered
+	time.Sleep(5 * time.Millisecond)
+	if err := writer.UpdateWithDataChangeEvent(ctx, db, "test", mutation(resource.NewPatchSet().Set("Count", int64(2)))); err != nil {
+		t.Fatalf("SQLitePatcher.UpdateWithDataChangeEvent() error = %v", err)
+	}
+
+	stmt, limit, err := NewSpannerPatcher().listChangesStatement(&ChangeFilter{TableName: "Fruits", RowID: "1"})
+	if err != nil {
+		t.Fatalf("SpannerPatcher.listChangesStatement() error = %v", err)
+	}
+
+	args := make([]any, 0, len(stmt.Params))
+	for name, value := range stmt.Params {
+		args = append(args, sql.Named(name, value))
+	}
+
+	var events []*DataChangeEvent
+	if err := sqlscan.Select(ctx, db, &events, stmt.SQL, args...); err != nil {
+		t.Fatalf("sqlscan.Select() error = %v", err)
+	}
+
+	page, err := changePage(events, limit)
+	if err != nil {
+		t.Fatalf("changePage() error = %v", err)
+	}
+	if len(page.Changes) != 2 {
+		t.Fatalf("changePage() Changes = %d, want 2", len(page.Changes))
+	}
+
+	slices.Reverse(events)
+	got, err := replay(events, resource.NewKeySet("ID", "1"), NewRowStruct(Fruit{}))
+	if err != nil {
+		t.Fatalf("replay() error = %v", err)
+	}
+
+	want := &Fruit{ID: "1", Name: "apple", Count: 2}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("replay() = (%v),  want (%v)", got, want)
+	}
+}
@@ -0,0 +1,156 @@
+package patcher
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/cccteam/ccc/accesstypes"
+	"github.com/cccteam/ccc/resource"
+	"github.com/go-playground/errors/v5"
+)
+
+// mutationJSON is the serialized form of a Mutation. The RowStruct is not serialized,
+// it is resolved from TableName when the Mutation is unmarshaled.
+type mutationJSON struct {
+	Operation Operation                             `json:"operation"`
+	TableName accesstypes.Resource                  `json:"tableName"`
+	Keys      []keyPartJSON                         `json:"keys"`
+	Data      map[accesstypes.Field]json.RawMessage `json:"data,omitempty"`
+
+	Preconditions map[accesstypes.Field]json.RawMessage `json:"preconditions,omitempty"`
+}
+
+type keyPartJSON struct {
+	Field accesstypes.Field `json:"field"`
+	Value json.RawMessage   `json:"value"`
+}
+
+// MarshalJSON serializes the mutation so it can be queued and replayed with UnmarshalMutation
+func (m Mutation) MarshalJSON() ([]byte, error) {
+	if m.PatchSet == nil {
+		return nil, errors.Newf("mutation of %s has no PatchSet", m.TableName)
+	}
+
+	mj := mutationJSON{
+		Operation: m.Operation,
+		TableName: m.TableName,
+		Data:      make(map[accesstypes.Field]json.RawMessage, m.PatchSet.Len()),
+	}
+
+	for _, part := range m.PatchSet.KeySet().Parts() {
+		value, err := json.Marshal(part.Value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "json.Marshal(): key %s", part.Key)
+		}
+		mj.Keys = append(mj.Keys, keyPartJSON{Field: part.Key, Value: value})
+	}
+
+	for field, v := range m.PatchSet.Data() {
+		value, err := json.Marshal(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "json.Marshal(): field %s", field)
+		}
+		mj.Data[field] = value
+	}
+
+	if len(m.Preconditions) > 0 {
+		mj.Preconditions = make(map[accesstypes.Field]json.RawMessage, len(m.Preconditions))
+		for field, v := range m.Preconditions {
+			value, err := json.Marshal(v)
+			if err != nil {
+				return nil, errors.Wrapf(err, "json.Marshal(): precondition %s", field)
+			}
+			mj.Preconditions[field] = value
+		}
+	}
+
+	b, err := json.Marshal(mj)
+	if err != nil {
+		return nil, errors.Wrap(err, "json.Marshal()")
+	}
+
+	return b, nil
+}
+
+// UnmarshalMutation restores a Mutation serialized with Mutation.MarshalJSON. The RowStruct is looked
+// up in rowStructs by TableName, and is used to decode each value into the type of its struct field.
+func UnmarshalMutation(data []byte, rowStructs map[accesstypes.Resource]RowStruct) (*Mutation, error) {
+	var mj mutationJSON
+	if err := json.Unmarshal(data, &mj); err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal()")
+	}
+
+	switch mj.Operation {
+	case OperationCreate, OperationUpdate, OperationUpsert, OperationDelete:
+	default:
+		return nil, errors.Newf("unsupported operation %q", mj.Operation)
+	}
+
+	row, ok := rowStructs[mj.TableName]
+	if !ok {
+		return nil, errors.Newf("no RowStruct for table %s", mj.TableName)
+	}
+	rowType := rowStructType(row)
+
+	patchSet := resource.NewPatchSet()
+	for field, raw := range mj.Data {
+		value, err := decodeField(rowType, field, raw)
+		if err != nil {
+			return nil, err
+		}
+		patchSet.Set(field, value)
+	}
+
+	for _, part := range mj.Keys {
+		value, err := decodeField(rowType, part.Field, part.Value)
+		if err != nil {
+			return nil, err
+		}
+		patchSet.SetKey(part.Field, value)
+	}
+
+	var preconditions map[accesstypes.Field]any
+	if len(mj.Preconditions) > 0 {
+		preconditions = make(map[accesstypes.Field]any, len(mj.Preconditions))
+		for field, raw := range mj.Preconditions {
+			value, err := decodeField(rowType, field, raw)
+			if err != nil {
+				return nil, err
+			}
+			preconditions[field] = value
+		}
+	}
+
+	return &Mutation{
+		Operation:     mj.Operation,
+		TableName:     mj.TableName,
+		RowStruct:     row,
+		PatchSet:      patchSet,
+		Preconditions: preconditions,
+	}, nil
+}
+
+// rowStructType returns the struct type described by row
+func rowStructType(row RowStruct) reflect.Type {
+	t := reflect.TypeOf(row.Type())
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	return t
+}
+
+// decodeField unmarshals raw into a value having the type of field in rowType
+func decodeField(rowType reflect.Type, field accesstypes.Field, raw json.RawMessage) (any, error) {
+	structField, ok := rowType.FieldByName(string(field))
+	if !ok {
+		return nil, errors.Newf("field %s not found in struct", field)
+	}
+
+	value := reflect.New(structField.Type)
+	if err := json.Unmarshal(raw, value.Interface()); err != nil {
+		return nil, errors.Wrapf(err, "json.Unmarshal(): field %s", field)
+	}
+
+	return value.Elem().Interface(), nil
+}
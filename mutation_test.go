@@ -0,0 +1,117 @@
+package patcher
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cccteam/ccc"
+	"github.com/cccteam/ccc/accesstypes"
+	"github.com/cccteam/ccc/resource"
+)
+
+func TestUnmarshalMutation(t *testing.T) {
+	t.Parallel()
+
+	type Fruit struct {
+		ID        ccc.UUID  `spanner:"Id"`
+		Variety   string    `spanner:"Variety"`
+		Count     int64     `spanner:"Count"`
+		Ripe      *bool     `spanner:"Ripe"`
+		PickedAt  time.Time `spanner:"PickedAt"`
+		Nicknames []string  `spanner:"Nicknames"`
+	}
+
+	id := ccc.Must(ccc.UUIDFromString("a517b48d-63a9-4c1f-b45b-8474b164e423"))
+	pickedAt := time.Date(2032, 4, 23, 12, 2, 3, 4, time.UTC)
+
+	patchSet := resource.NewPatchSet().
+		Set("Count", int64(10)).
+		Set("Ripe", ccc.Ptr(true)).
+		Set("PickedAt", pickedAt).
+		Set("Nicknames", []string{"a", "b"})
+	patchSet.SetKey("ID", id)
+	patchSet.SetKey("Variety", "gala")
+
+	want := &Mutation{
+		Operation: OperationUpdate,
+		TableName: "Fruits",
+		RowStruct: NewRowStruct(Fruit{}),
+		PatchSet:  patchSet,
+
+		Preconditions: map[accesstypes.Field]any{"Count": int64(9)},
+	}
+
+	b, err := json.Marshal(want)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+
+	got, err := UnmarshalMutation(b, map[accesstypes.Resource]RowStruct{"Fruits": want.RowStruct})
+	if err != nil {
+		t.Fatalf("UnmarshalMutation() error = %v", err)
+	}
+
+	if got.Operation != want.Operation || got.TableName != want.TableName {
+		t.Errorf("UnmarshalMutation() = (%s %s),  want (%s %s)", got.Operation, got.TableName, want.Operation, want.TableName)
+	}
+	if got.PatchSet.KeySet().RowID() != want.PatchSet.KeySet().RowID() {
+		t.Errorf("UnmarshalMutation() RowID = (%v),  want (%v)", got.PatchSet.KeySet().RowID(), want.PatchSet.KeySet().RowID())
+	}
+	if !reflect.DeepEqual(got.PatchSet.KeySet().KeyMap(), want.PatchSet.KeySet().KeyMap()) {
+		t.Errorf("UnmarshalMutation() keys = (%v),  want (%v)", got.PatchSet.KeySet().KeyMap(), want.PatchSet.KeySet().KeyMap())
+	}
+
+	if !reflect.DeepEqual(got.Preconditions, want.Preconditions) {
+		t.Errorf("UnmarshalMutation() Preconditions = (%v),  want (%v)", got.Preconditions, want.Preconditions)
+	}
+
+	diff, err := newPatcher("spanner", SpannerDialect{}).Diff(&Fruit{Count: 10, Ripe: ccc.Ptr(true), PickedAt: pickedAt, Nicknames: []string{"a", "b"}}, got.PatchSet)
+	if err != nil {
+		t.Fatalf("patcher.Diff() error = %v", err)
+	}
+	if len(diff) != 0 {
+		t.Errorf("patcher.Diff() = %v, want no changes", diff)
+	}
+
+	if _, err := UnmarshalMutation(b, nil); err == nil {
+		t.Errorf("UnmarshalMutation() error = nil, want error for unknown table")
+	}
+}
+
+func TestMutation_MarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	type Fruit struct {
+		ID   string `spanner:"Id"`
+		Name string `spanner:"Name"`
+	}
+
+	patchSet := resource.NewPatchSet().Set("Name", "apple")
+	patchSet.SetKey("ID", "1")
+	mutation := Mutation{Operation: OperationCreate, TableName: "Fruits", RowStruct: NewRowStruct(Fruit{}), PatchSet: patchSet}
+
+	// Mutations held by value are encoded the same as by pointer
+	b, err := json.Marshal([]Mutation{mutation})
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+
+	var raw []json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+
+	got, err := UnmarshalMutation(raw[0], map[accesstypes.Resource]RowStruct{"Fruits": mutation.RowStruct})
+	if err != nil {
+		t.Fatalf("UnmarshalMutation() error = %v", err)
+	}
+	if got.PatchSet.KeySet().RowID() != "1" || !reflect.DeepEqual(got.PatchSet.Data(), patchSet.Data()) {
+		t.Errorf("UnmarshalMutation() = (%v %v),  want (%v %v)", got.PatchSet.KeySet().RowID(), got.PatchSet.Data(), "1", patchSet.Data())
+	}
+
+	if _, err := json.Marshal(&Mutation{Operation: OperationCreate, TableName: "Fruits"}); err == nil {
+		t.Errorf("json.Marshal() error = nil, want error for nil PatchSet")
+	}
+}
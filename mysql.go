@@ -0,0 +1,99 @@
+package patcher
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/cccteam/ccc/accesstypes"
+)
+
+// MySQLDialect is the Dialect for MySQL and MariaDB
+type MySQLDialect struct{}
+
+func (MySQLDialect) Name() string {
+	return "mysql"
+}
+
+func (MySQLDialect) QuoteIdentifier(identifier string) string {
+	return "`" + strings.ReplaceAll(identifier, "`", "``") + "`"
+}
+
+func (MySQLDialect) Placeholder(string, int) string {
+	return "?"
+}
+
+// BindArgs returns the values of params in order
+func (MySQLDialect) BindArgs(params []Param) []any {
+	args := make([]any, 0, len(params))
+	for _, param := range params {
+		args = append(args, param.Value)
+	}
+
+	return args
+}
+
+// Upsert uses VALUES() to reference the inserted values, which is supported by both MySQL and MariaDB
+func (MySQLDialect) Upsert(table string, columns, values, keyColumns []string) string {
+	set := make([]string, 0, len(columns))
+	for _, column := range columns {
+		if slices.Contains(keyColumns, column) {
+			continue
+		}
+		set = append(set, fmt.Sprintf("%s = VALUES(%s)", column, column))
+	}
+
+	if len(set) == 0 {
+		set = append(set, fmt.Sprintf("%s = %s", keyColumns[0], keyColumns[0]))
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON DUPLICATE KEY UPDATE %s",
+		table, strings.Join(columns, ", "), strings.Join(values, ", "), strings.Join(set, ", "),
+	)
+}
+
+func (MySQLDialect) ForUpdate() string {
+	return "FOR UPDATE"
+}
+
+func (MySQLDialect) CommitTimestamp() string {
+	return "CURRENT_TIMESTAMP(6)"
+}
+
+// MySQLPatcher is a SQLPatcher using the MySQLDialect
+type MySQLPatcher struct {
+	*SQLPatcher
+}
+
+func NewMySQLPatcher() *MySQLPatcher {
+	return &MySQLPatcher{
+		SQLPatcher: NewSQLPatcher(MySQLDialect{}),
+	}
+}
+
+func (p *MySQLPatcher) WithDataChangeTableName(tableName string) *MySQLPatcher {
+	p.changeTrackingTable = tableName
+
+	return p
+}
+
+// WithDataChangeEventColumns enables the optional columns of the change tracking table, see DataChangeEventColumn
+func (p *MySQLPatcher) WithDataChangeEventColumns(columns ...DataChangeEventColumn) *MySQLPatcher {
+	p.eventColumns = columns
+
+	return p
+}
+
+// WithTableAudit enables or disables recording the data change events of tableNames, see SpannerPatcher.WithTableAudit
+func (p *MySQLPatcher) WithTableAudit(enabled bool, tableNames ...accesstypes.Resource) *MySQLPatcher {
+	p.audit.setTables(enabled, tableNames)
+
+	return p
+}
+
+// WithAuditHashKey sets the key of the audit option hash, see SpannerPatcher.WithAuditHashKey
+func (p *MySQLPatcher) WithAuditHashKey(key []byte) *MySQLPatcher {
+	p.audit.hashKey = key
+
+	return p
+}
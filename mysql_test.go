@@ -0,0 +1,250 @@
+package patcher
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cccteam/ccc/resource"
+	"github.com/cccteam/httpio"
+)
+
+// fakeConnector is an in-process database/sql driver recording the statements it receives.
+// Queries are answered by rows, which returns the columns and values of the result, and statements
+// affect the number of rows returned by rowsAffected, or 1 when it is not set.
+type fakeConnector struct {
+	rows         func(query string, args []driver.NamedValue) (columns []string, values [][]driver.Value)
+	rowsAffected func(query string) int64
+
+	mu         sync.Mutex
+	statements []string
+	args       [][]driver.NamedValue
+	committed  bool
+}
+
+func (c *fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{connector: c}, nil
+}
+
+func (c *fakeConnector) Driver() driver.Driver {
+	return nil
+}
+
+func (c *fakeConnector) record(query string, args []driver.NamedValue) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.statements = append(c.statements, strings.Join(strings.Fields(query), " "))
+	c.args = append(c.args, args)
+}
+
+type fakeConn struct {
+	connector *fakeConnector
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, driver.ErrSkip
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.connector.mu.Lock()
+	defer c.connector.mu.Unlock()
+
+	c.connector.committed = true
+
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	return nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.connector.record(query, args)
+
+	if c.connector.rowsAffected != nil {
+		return driver.RowsAffected(c.connector.rowsAffected(query)), nil
+	}
+
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.connector.record(query, args)
+
+	var columns []string
+	var values [][]driver.Value
+	if c.connector.rows != nil {
+		columns, values = c.connector.rows(query, args)
+	}
+
+	return &fakeRows{columns: columns, values: values}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+
+	return nil
+}
+
+func TestMySQLPatcher_UpdateWithDataChangeEvent(t *testing.T) {
+	t.Parallel()
+
+	type Fruit struct {
+		ID    string `db:"Id"`
+		Name  string `db:"Name"`
+		Count int64  `db:"Count"`
+	}
+
+	connector := &fakeConnector{
+		rows: func(string, []driver.NamedValue) ([]string, [][]driver.Value) {
+			return []string{"Name"}, [][]driver.Value{{"apple"}}
+		},
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	mutation := &Mutation{
+		TableName: "Fruits",
+		RowStruct: NewRowStruct(Fruit{}),
+		PatchSet:  resource.NewPatchSet().Set("Name", "banana"),
+	}
+	mutation.PatchSet.SetKey("ID", "1")
+
+	if err := NewMySQLPatcher().WithDataChangeEventColumns(EventTypeColumn, ChangeSetVersionColumn).UpdateWithDataChangeEvent(context.Background(), db, "test", mutation); err != nil {
+		t.Fatalf("MySQLPatcher.UpdateWithDataChangeEvent() error = %v", err)
+	}
+
+	want := []string{
+		"SELECT `Name` FROM `Fruits` WHERE `Id` = ? FOR UPDATE",
+		"UPDATE `Fruits` SET `Id` = ?, `Name` = ? WHERE `Id` = ?",
+		"INSERT INTO `DataChangeEvents` (`ChangeSet`, `ChangeSetVersion`, `EventSource`, `EventTime`, `EventType`, `RowId`, `TableName`) VALUES (?, ?, ?, CURRENT_TIMESTAMP(6), ?, ?, ?)",
+	}
+	if len(connector.statements) != len(want) {
+		t.Fatalf("statements = %v, want %v", connector.statements, want)
+	}
+	for i := range want {
+		if connector.statements[i] != want[i] {
+			t.Errorf("statements[%d] = (%v),  want (%v)", i, connector.statements[i], want[i])
+		}
+	}
+
+	if got := connector.args[2][0].Value; got != `{"Name":{"Old":"apple","New":"banana"}}` {
+		t.Errorf("ChangeSet = (%v),  want (%v)", got, `{"Name":{"Old":"apple","New":"banana"}}`)
+	}
+
+	if got := connector.args[2][3].Value; got != string(EventTypeUpdate) {
+		t.Errorf("EventType = (%v),  want (%v)", got, EventTypeUpdate)
+	}
+
+	if !connector.committed {
+		t.Errorf("transaction was not committed")
+	}
+}
+
+func TestMySQLPatcher_Update_unchanged(t *testing.T) {
+	t.Parallel()
+
+	type Fruit struct {
+		ID   string `db:"Id"`
+		Name string `db:"Name"`
+	}
+
+	tests := []struct {
+		name         string
+		exists       bool
+		wantNotFound bool
+	}{
+		{name: "row exists", exists: true},
+		{name: "row does not exist", wantNotFound: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// MySQL reports the rows changed by an update, so an update writing the current values affects no rows
+			connector := &fakeConnector{
+				rowsAffected: func(string) int64 { return 0 },
+				rows: func(string, []driver.NamedValue) ([]string, [][]driver.Value) {
+					if !tt.exists {
+						return []string{"1"}, nil
+					}
+
+					return []string{"1"}, [][]driver.Value{{int64(1)}}
+				},
+			}
+			db := sql.OpenDB(connector)
+			defer db.Close()
+
+			mutation := &Mutation{TableName: "Fruits", RowStruct: NewRowStruct(Fruit{}), PatchSet: resource.NewPatchSet().Set("Name", "apple")}
+			mutation.PatchSet.SetKey("ID", "1")
+
+			err := NewMySQLPatcher().Update(context.Background(), db, mutation)
+			if got := httpio.HasNotFound(err); got != tt.wantNotFound {
+				t.Fatalf("MySQLPatcher.Update() error = %v, wantNotFound %v", err, tt.wantNotFound)
+			}
+
+			want := []string{
+				"UPDATE `Fruits` SET `Id` = ?, `Name` = ? WHERE `Id` = ?",
+				"SELECT 1 FROM `Fruits` WHERE `Id` = ? FOR UPDATE",
+			}
+			if !slices.Equal(connector.statements, want) {
+				t.Errorf("statements = (%v),  want (%v)", connector.statements, want)
+			}
+		})
+	}
+}
+
+func TestMySQLDialect_Upsert(t *testing.T) {
+	t.Parallel()
+
+	type Fruit struct {
+		ID   string `db:"Id"`
+		Name string `db:"Name"`
+	}
+
+	p := NewMySQLPatcher()
+	got, args, err := p.upsertStatement("Fruits", map[string]any{"Id": "1", "Name": "apple"}, resource.NewKeySet("ID", "1"), Fruit{})
+	if err != nil {
+		t.Fatalf("patcher.upsertStatement() error = %v", err)
+	}
+
+	want := "INSERT INTO `Fruits` (`Id`, `Name`) VALUES (?, ?) ON DUPLICATE KEY UPDATE `Name` = VALUES(`Name`)"
+	if got != want {
+		t.Errorf("patcher.upsertStatement() = (%v),  want (%v)", got, want)
+	}
+	if len(args) != 2 || args[0] != "1" || args[1] != "apple" {
+		t.Errorf("patcher.upsertStatement() args = (%v),  want ([1 apple])", args)
+	}
+}
@@ -0,0 +1,344 @@
+package patcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/cccteam/ccc"
+	"github.com/cccteam/logger"
+	"github.com/cccteam/spxscan"
+	"github.com/go-playground/errors/v5"
+)
+
+var _ ChangeEventSink = (*OutboxSink)(nil)
+
+// OutboxSink buffers a row into a transactional outbox table for each data change event, so the event is
+// committed with the change, and is published by an OutboxRelay after it is committed. The table must have the columns
+//
+//	MessageId STRING(36) NOT NULL,
+//	TableName STRING(MAX) NOT NULL,
+//	RowId STRING(MAX) NOT NULL,
+//	Event STRING(MAX) NOT NULL,
+//	CreatedAt TIMESTAMP NOT NULL OPTIONS (allow_commit_timestamp=true),
+//	Attempts INT64 NOT NULL,
+//	NextAttemptAt TIMESTAMP,
+//	LastError STRING(MAX),
+//	DeliveredAt TIMESTAMP OPTIONS (allow_commit_timestamp=true),
+//
+// with MessageId as primary key.
+type OutboxSink struct {
+	tableName string
+}
+
+// NewOutboxSink returns a sink writing data change events into the outbox table tableName.
+// Register it with SpannerPatcher.WithChangeEventSink.
+func NewOutboxSink(tableName string) *OutboxSink {
+	return &OutboxSink{tableName: tableName}
+}
+
+func (s *OutboxSink) BufferDataChangeEvent(_ context.Context, txn *spanner.ReadWriteTransaction, event *DataChangeEvent) error {
+	columns, err := outboxColumns(event)
+	if err != nil {
+		return err
+	}
+
+	if err := txn.BufferWrite([]*spanner.Mutation{spanner.InsertMap(s.tableName, columns)}); err != nil {
+		return errors.Wrap(err, "spanner.ReadWriteTransaction.BufferWrite()")
+	}
+
+	return nil
+}
+
+// outboxColumns returns the columns of the outbox row of event
+func outboxColumns(event *DataChangeEvent) (map[string]any, error) {
+	messageID, err := ccc.NewUUID()
+	if err != nil {
+		return nil, errors.Wrap(err, "ccc.NewUUID()")
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, errors.Wrap(err, "json.Marshal()")
+	}
+
+	return map[string]any{
+		"MessageId": messageID.String(),
+		"TableName": string(event.TableName),
+		"RowId":     event.RowID,
+		"Event":     string(payload),
+		"CreatedAt": spanner.CommitTimestamp,
+		"Attempts":  int64(0),
+	}, nil
+}
+
+// OutboxMessage is a data change event published from the outbox
+type OutboxMessage struct {
+	// ID identifies the message. Messages are delivered at least once, so consumers use it to discard duplicates.
+	ID string
+
+	// Attempt is the number of the delivery attempt, starting at 1
+	Attempt int64
+
+	// Event is the data change event, with EventTime set to the commit timestamp of the change. Its ChangeSet
+	// is encrypted when ChangeSet encryption is enabled, see SpannerPatcher.DecryptDataChangeEvent.
+	Event DataChangeEvent
+}
+
+// Publisher publishes outbox messages to downstream consumers. Publish must return an
+// error unless the message has been durably accepted, in which case it is retried later.
+type Publisher interface {
+	Publish(ctx context.Context, message *OutboxMessage) error
+}
+
+// PublisherFunc adapts a function to the Publisher interface
+type PublisherFunc func(ctx context.Context, message *OutboxMessage) error
+
+func (f PublisherFunc) Publish(ctx context.Context, message *OutboxMessage) error {
+	return f(ctx, message)
+}
+
+var (
+	_ Publisher = PublisherFunc(nil)
+	_ Publisher = (*MemoryPublisher)(nil)
+)
+
+// MemoryPublisher collects published messages in memory, which is mostly useful in tests
+type MemoryPublisher struct {
+	mu       sync.Mutex
+	messages []OutboxMessage
+}
+
+// NewMemoryPublisher returns an empty MemoryPublisher
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+func (p *MemoryPublisher) Publish(_ context.Context, message *OutboxMessage) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.messages = append(p.messages, *message)
+
+	return nil
+}
+
+// Messages returns the published messages, in the order they were published
+func (p *MemoryPublisher) Messages() []OutboxMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return slices.Clone(p.messages)
+}
+
+// outboxRow is an undelivered row of the outbox table
+type outboxRow struct {
+	MessageID string    `spanner:"MessageId"`
+	Event     string    `spanner:"Event"`
+	CreatedAt time.Time `spanner:"CreatedAt"`
+	Attempts  int64     `spanner:"Attempts"`
+}
+
+// message returns the OutboxMessage published for the row
+func (r *outboxRow) message() (*OutboxMessage, error) {
+	message := &OutboxMessage{ID: r.MessageID, Attempt: r.Attempts + 1}
+	if err := json.Unmarshal([]byte(r.Event), &message.Event); err != nil {
+		return nil, errors.Wrapf(err, "json.Unmarshal(): message %s", r.MessageID)
+	}
+	message.Event.EventTime = r.CreatedAt
+
+	return message, nil
+}
+
+// OutboxRelay polls an outbox table written by an OutboxSink, publishes the undelivered rows, oldest first,
+// and marks them delivered. A row which fails to publish is retried with an exponential delay until it has been
+// attempted the maximum number of times, after which it is left undelivered, with the error of its last attempt.
+//
+// Messages are delivered at least once: a message is published again when marking it delivered fails,
+// or when it is published by relays running concurrently.
+type OutboxRelay struct {
+	store         outboxStore
+	publisher     Publisher
+	batchSize     int64
+	maxAttempts   int64
+	pollInterval  time.Duration
+	retryDelay    time.Duration
+	maxRetryDelay time.Duration
+}
+
+// NewOutboxRelay returns a relay publishing the rows of the outbox table tableName with publisher
+func NewOutboxRelay(client *spanner.Client, tableName string, publisher Publisher) *OutboxRelay {
+	return &OutboxRelay{
+		store:         &spannerOutboxStore{client: client, tableName: tableName},
+		publisher:     publisher,
+		batchSize:     100,
+		maxAttempts:   10,
+		pollInterval:  5 * time.Second,
+		retryDelay:    time.Second,
+		maxRetryDelay: 5 * time.Minute,
+	}
+}
+
+// WithBatchSize sets the maximum number of rows published by each poll. A batch size which is not positive is ignored.
+func (r *OutboxRelay) WithBatchSize(batchSize int64) *OutboxRelay {
+	if batchSize > 0 {
+		r.batchSize = batchSize
+	}
+
+	return r
+}
+
+// WithMaxAttempts sets the number of times a row is attempted before it is left undelivered
+func (r *OutboxRelay) WithMaxAttempts(maxAttempts int64) *OutboxRelay {
+	r.maxAttempts = maxAttempts
+
+	return r
+}
+
+// WithPollInterval sets the interval between polls of Run when there are no more rows to publish
+func (r *OutboxRelay) WithPollInterval(pollInterval time.Duration) *OutboxRelay {
+	r.pollInterval = pollInterval
+
+	return r
+}
+
+// WithRetryDelay sets the delay before the first retry of a row, which is doubled by each following retry up to maxRetryDelay
+func (r *OutboxRelay) WithRetryDelay(retryDelay, maxRetryDelay time.Duration) *OutboxRelay {
+	r.retryDelay = retryDelay
+	r.maxRetryDelay = maxRetryDelay
+
+	return r
+}
+
+// Run publishes the outbox rows until ctx is done, returning the error of ctx
+func (r *OutboxRelay) Run(ctx context.Context) error {
+	for {
+		delivered, err := r.RelayOnce(ctx)
+		if err != nil {
+			logger.Ctx(ctx).Errorf("OutboxRelay.RelayOnce(): %s", err)
+		}
+
+		if err == nil && int64(delivered) == r.batchSize && ctx.Err() == nil {
+			// There may be more rows ready to publish
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.pollInterval):
+		}
+	}
+}
+
+// RelayOnce publishes a batch of the outbox rows ready to be published, returning the number of rows delivered.
+// Rows which fail to publish are scheduled to be retried, and do not fail RelayOnce.
+func (r *OutboxRelay) RelayOnce(ctx context.Context) (int, error) {
+	rows, err := r.store.pending(ctx, r.maxAttempts, r.batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var delivered int
+	for _, row := range rows {
+		message, err := row.message()
+		if err == nil {
+			err = r.publisher.Publish(ctx, message)
+		}
+		if err != nil {
+			logger.Ctx(ctx).Warnf("failed to publish outbox message %s (attempt %d): %s", row.MessageID, row.Attempts+1, err)
+			attempts := row.Attempts + 1
+			if err := r.store.markFailed(ctx, row, attempts, err.Error(), time.Now().Add(r.nextRetryDelay(attempts))); err != nil {
+				return delivered, err
+			}
+
+			continue
+		}
+
+		if err := r.store.markDelivered(ctx, row); err != nil {
+			return delivered, err
+		}
+		delivered++
+	}
+
+	return delivered, nil
+}
+
+// nextRetryDelay returns the delay before the next attempt of a row which has failed attempts times
+func (r *OutboxRelay) nextRetryDelay(attempts int64) time.Duration {
+	delay := r.retryDelay
+	for i := int64(1); i < attempts && delay < r.maxRetryDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, r.maxRetryDelay)
+}
+
+// outboxStore reads and updates the rows of an outbox table for an OutboxRelay
+type outboxStore interface {
+	// pending reads up to limit undelivered rows which have been attempted less than maxAttempts times, and are ready to be attempted, oldest first
+	pending(ctx context.Context, maxAttempts, limit int64) ([]*outboxRow, error)
+
+	markDelivered(ctx context.Context, row *outboxRow) error
+
+	// markFailed records a failed attempt of row, which has been attempted attempts times, to be retried at nextAttemptAt
+	markFailed(ctx context.Context, row *outboxRow, attempts int64, lastError string, nextAttemptAt time.Time) error
+}
+
+var _ outboxStore = (*spannerOutboxStore)(nil)
+
+// spannerOutboxStore is the outboxStore of an outbox table in Spanner
+type spannerOutboxStore struct {
+	client    *spanner.Client
+	tableName string
+}
+
+func (s *spannerOutboxStore) pending(ctx context.Context, maxAttempts, limit int64) ([]*outboxRow, error) {
+	stmt := spanner.NewStatement(fmt.Sprintf(`
+			SELECT
+				MessageId, Event, CreatedAt, Attempts
+			FROM %s
+			WHERE DeliveredAt IS NULL
+				AND Attempts < @maxAttempts
+				AND (NextAttemptAt IS NULL OR NextAttemptAt <= CURRENT_TIMESTAMP())
+			ORDER BY CreatedAt
+			LIMIT @limit`, s.tableName,
+	))
+	stmt.Params["maxAttempts"] = maxAttempts
+	stmt.Params["limit"] = limit
+
+	var rows []*outboxRow
+	if err := spxscan.Select(ctx, s.client.Single(), &rows, stmt); err != nil {
+		return nil, errors.Wrap(err, "spxscan.Select()")
+	}
+
+	return rows, nil
+}
+
+func (s *spannerOutboxStore) markDelivered(ctx context.Context, row *outboxRow) error {
+	if _, err := s.client.Apply(ctx, []*spanner.Mutation{spanner.UpdateMap(s.tableName, map[string]any{
+		"MessageId":   row.MessageID,
+		"DeliveredAt": spanner.CommitTimestamp,
+	})}); err != nil {
+		return errors.Wrap(err, "spanner.Client.Apply()")
+	}
+
+	return nil
+}
+
+func (s *spannerOutboxStore) markFailed(ctx context.Context, row *outboxRow, attempts int64, lastError string, nextAttemptAt time.Time) error {
+	if _, err := s.client.Apply(ctx, []*spanner.Mutation{spanner.UpdateMap(s.tableName, map[string]any{
+		"MessageId":     row.MessageID,
+		"Attempts":      attempts,
+		"LastError":     lastError,
+		"NextAttemptAt": nextAttemptAt,
+	})}); err != nil {
+		return errors.Wrap(err, "spanner.Client.Apply()")
+	}
+
+	return nil
+}
@@ -0,0 +1,255 @@
+package patcher
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+)
+
+func Test_outboxColumns_message(t *testing.T) {
+	t.Parallel()
+
+	correlationID := "request-1"
+	event := &DataChangeEvent{
+		TableName:        "Fruits",
+		RowID:            "1",
+		EventSource:      `{"processName":"importer"}`,
+		EventType:        EventTypeUpdate,
+		ChangeSet:        `{"Name":{"Old":"apple","New":"pear"}}`,
+		ChangeSetVersion: ChangeSetVersion,
+		CorrelationID:    &correlationID,
+	}
+
+	columns, err := outboxColumns(event)
+	if err != nil {
+		t.Fatalf("outboxColumns() error = %v", err)
+	}
+	if columns["CreatedAt"] != spanner.CommitTimestamp {
+		t.Errorf("outboxColumns()[CreatedAt] = (%v),  want (%v)", columns["CreatedAt"], spanner.CommitTimestamp)
+	}
+	if columns["TableName"] != "Fruits" || columns["RowId"] != "1" {
+		t.Errorf("outboxColumns() = (%v),  want TableName Fruits and RowId 1", columns)
+	}
+
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	row := &outboxRow{MessageID: columns["MessageId"].(string), Event: columns["Event"].(string), CreatedAt: createdAt, Attempts: 2}
+
+	got, err := row.message()
+	if err != nil {
+		t.Fatalf("outboxRow.message() error = %v", err)
+	}
+
+	want := &OutboxMessage{ID: row.MessageID, Attempt: 3, Event: *event}
+	want.Event.EventTime = createdAt
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("outboxRow.message() = (%v),  want (%v)", got, want)
+	}
+}
+
+func TestOutboxRelay_nextRetryDelay(t *testing.T) {
+	t.Parallel()
+
+	relay := NewOutboxRelay(nil, "Outbox", NewMemoryPublisher()).WithRetryDelay(time.Second, time.Minute)
+
+	tests := []struct {
+		name     string
+		attempts int64
+		want     time.Duration
+	}{
+		{name: "first retry", attempts: 1, want: time.Second},
+		{name: "doubled", attempts: 3, want: 4 * time.Second},
+		{name: "capped", attempts: 7, want: time.Minute},
+		{name: "capped without overflow", attempts: 100, want: time.Minute},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := relay.nextRetryDelay(tt.attempts); got != tt.want {
+				t.Errorf("OutboxRelay.nextRetryDelay() = (%v),  want (%v)", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeOutboxStore is an outboxStore holding its rows in memory
+type fakeOutboxStore struct {
+	rows      []*outboxRow
+	delivered map[string]bool
+	lastError map[string]string
+}
+
+func newFakeOutboxStore(rows ...*outboxRow) *fakeOutboxStore {
+	return &fakeOutboxStore{rows: rows, delivered: make(map[string]bool), lastError: make(map[string]string)}
+}
+
+func (s *fakeOutboxStore) pending(_ context.Context, maxAttempts, limit int64) ([]*outboxRow, error) {
+	var rows []*outboxRow
+	for _, row := range s.rows {
+		if !s.delivered[row.MessageID] && row.Attempts < maxAttempts && int64(len(rows)) < limit {
+			c := *row
+			rows = append(rows, &c)
+		}
+	}
+
+	return rows, nil
+}
+
+func (s *fakeOutboxStore) markDelivered(_ context.Context, row *outboxRow) error {
+	s.delivered[row.MessageID] = true
+
+	return nil
+}
+
+func (s *fakeOutboxStore) markFailed(_ context.Context, row *outboxRow, attempts int64, lastError string, _ time.Time) error {
+	for _, r := range s.rows {
+		if r.MessageID == row.MessageID {
+			r.Attempts = attempts
+		}
+	}
+	s.lastError[row.MessageID] = lastError
+
+	return nil
+}
+
+func TestOutboxRelay_RelayOnce(t *testing.T) {
+	t.Parallel()
+
+	newRow := func(id string) *outboxRow {
+		return &outboxRow{MessageID: id, Event: `{"TableName":"Fruits","RowID":"` + id + `"}`, CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	}
+
+	tests := []struct {
+		name          string
+		publishErr    error
+		wantDelivered int
+		wantAttempts  int64
+		wantPending   int
+	}{
+		{name: "published", wantDelivered: 2, wantPending: 0},
+		{name: "publish error", publishErr: errors.New("unavailable"), wantDelivered: 0, wantAttempts: 1, wantPending: 2},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			store := newFakeOutboxStore(newRow("1"), newRow("2"))
+
+			var published []*OutboxMessage
+			relay := NewOutboxRelay(nil, "Outbox", PublisherFunc(func(_ context.Context, message *OutboxMessage) error {
+				published = append(published, message)
+
+				return tt.publishErr
+			}))
+			relay.store = store
+
+			delivered, err := relay.RelayOnce(ctx)
+			if err != nil {
+				t.Fatalf("OutboxRelay.RelayOnce() error = %v", err)
+			}
+			if delivered != tt.wantDelivered {
+				t.Errorf("OutboxRelay.RelayOnce() = (%d),  want (%d)", delivered, tt.wantDelivered)
+			}
+
+			if len(published) != 2 || published[0].ID != "1" || published[0].Attempt != 1 || published[0].Event.RowID != "1" {
+				t.Errorf("published = (%v),  want messages 1 and 2 on their first attempt", published)
+			}
+
+			pending, err := store.pending(ctx, relay.maxAttempts, relay.batchSize)
+			if err != nil {
+				t.Fatalf("fakeOutboxStore.pending() error = %v", err)
+			}
+			if len(pending) != tt.wantPending {
+				t.Fatalf("pending = (%d),  want (%d)", len(pending), tt.wantPending)
+			}
+			for _, row := range pending {
+				if row.Attempts != tt.wantAttempts {
+					t.Errorf("Attempts of %s = (%d),  want (%d)", row.MessageID, row.Attempts, tt.wantAttempts)
+				}
+				if store.lastError[row.MessageID] != tt.publishErr.Error() {
+					t.Errorf("LastError of %s = (%v),  want (%v)", row.MessageID, store.lastError[row.MessageID], tt.publishErr)
+				}
+			}
+			for _, row := range store.rows {
+				if store.delivered[row.MessageID] != (tt.publishErr == nil) {
+					t.Errorf("delivered %s = (%v),  want (%v)", row.MessageID, store.delivered[row.MessageID], tt.publishErr == nil)
+				}
+			}
+		})
+	}
+}
+
+func TestOutboxRelay_RelayOnce_maxAttempts(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := newFakeOutboxStore(&outboxRow{MessageID: "1", Event: `{}`})
+	relay := NewOutboxRelay(nil, "Outbox", PublisherFunc(func(context.Context, *OutboxMessage) error {
+		return errors.New("unavailable")
+	})).WithMaxAttempts(2)
+	relay.store = store
+
+	for range 3 {
+		if _, err := relay.RelayOnce(ctx); err != nil {
+			t.Fatalf("OutboxRelay.RelayOnce() error = %v", err)
+		}
+	}
+
+	// The row is left undelivered once it has been attempted the maximum number of times
+	if store.rows[0].Attempts != 2 || store.delivered["1"] {
+		t.Errorf("Attempts = (%d), delivered = (%v),  want (2), (false)", store.rows[0].Attempts, store.delivered["1"])
+	}
+}
+
+func TestOutboxRelay_WithBatchSize(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		batchSize int64
+		want      int64
+	}{
+		{name: "positive", batchSize: 10, want: 10},
+		{name: "zero", batchSize: 0, want: 100},
+		{name: "negative", batchSize: -1, want: 100},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := NewOutboxRelay(nil, "Outbox", NewMemoryPublisher()).WithBatchSize(tt.batchSize).batchSize; got != tt.want {
+				t.Errorf("OutboxRelay.WithBatchSize() = (%d),  want (%d)", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutboxRelay_Run_canceled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	store := newFakeOutboxStore(&outboxRow{MessageID: "1", Event: `{}`}, &outboxRow{MessageID: "2", Event: `{}`})
+
+	// Every batch is full, so Run only stops because ctx is done
+	relay := NewOutboxRelay(nil, "Outbox", PublisherFunc(func(context.Context, *OutboxMessage) error {
+		cancel()
+
+		return nil
+	})).WithBatchSize(1).WithPollInterval(time.Hour)
+	relay.store = store
+
+	if err := relay.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("OutboxRelay.Run() error = %v, want %v", err, context.Canceled)
+	}
+	if !store.delivered["1"] || store.delivered["2"] {
+		t.Errorf("delivered = (%v),  want only message 1", store.delivered)
+	}
+}
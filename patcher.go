@@ -3,8 +3,9 @@ package patcher
 
 import (
 	"bytes"
+	"context"
 	"encoding"
-	"fmt"
+	"encoding/json"
 	"iter"
 	"maps"
 	"reflect"
@@ -17,24 +18,60 @@ import (
 	"github.com/cccteam/ccc"
 	"github.com/cccteam/ccc/accesstypes"
 	"github.com/cccteam/ccc/resource"
+	"github.com/cccteam/httpio"
 	"github.com/go-playground/errors/v5"
 )
 
-type dbType string
-
-const (
-	spannerdbType  dbType = "spanner"
-	postgresdbType dbType = "postgres"
-)
+// Patcher is implemented by the database specific patchers. DB is the database handle
+// used to open a transaction, e.g. *spanner.Client for SpannerPatcher. Since DB differs by backend,
+// code which must not depend on the backend uses a Store, binding a Patcher to its handle, see NewStore.
+type Patcher[DB any] interface {
+	Dialect() Dialect
+	QuerySetColumns(querySet *resource.QuerySet, databaseType any) (string, error)
+	PatchSetColumns(patchSet *resource.PatchSet, databaseType any) (string, error)
+	AllColumns(databaseType any) (string, error)
+	NotDeleted(databaseType any) (string, error)
+	Where(keySet resource.KeySet, databaseType any) (where string, params map[string]any, err error)
+	WhereArgs(keySet resource.KeySet, databaseType any) (where string, args []any, err error)
+	Resolve(patchSet *resource.PatchSet, databaseType any) (map[string]any, error)
+	Diff(old any, patchSet *resource.PatchSet) (map[accesstypes.Field]DiffElem, error)
+
+	Insert(ctx context.Context, db DB, mutation *Mutation) error
+	Update(ctx context.Context, db DB, mutation *Mutation) error
+	InsertOrUpdate(ctx context.Context, db DB, mutation *Mutation) error
+	Delete(ctx context.Context, db DB, mutation *Mutation) error
+	InsertWithDataChangeEvent(ctx context.Context, db DB, eventSource string, mutation *Mutation) error
+	UpdateWithDataChangeEvent(ctx context.Context, db DB, eventSource string, mutation *Mutation) error
+	InsertOrUpdateWithDataChangeEvent(ctx context.Context, db DB, eventSource string, mutation *Mutation) error
+	DeleteWithDataChangeEvent(ctx context.Context, db DB, eventSource string, mutation *Mutation) error
+	Mutate(ctx context.Context, db DB, eventSource string, mutation *Mutation) error
+	Apply(ctx context.Context, db DB, eventSource string, mutations ...*Mutation) error
+}
 
 type patcher struct {
 	tagName string
-	dbType  dbType
+	dialect Dialect
+
+	// audit selects the tables recording data change events, see WithTableAudit and WithAuditHashKey
+	audit auditConfig
 
 	mu    sync.RWMutex
 	cache map[reflect.Type]map[accesstypes.Field]cacheEntry
 }
 
+func newPatcher(tagName string, dialect Dialect) *patcher {
+	return &patcher{
+		cache:   make(map[reflect.Type]map[accesstypes.Field]cacheEntry),
+		tagName: tagName,
+		dialect: dialect,
+	}
+}
+
+// Dialect returns the Dialect used to build statements
+func (p *patcher) Dialect() Dialect {
+	return p.dialect
+}
+
 // QuerySetColumns returns the database struct tags for the fields in databaseType that the user has access to view.
 func (p *patcher) QuerySetColumns(querySet *resource.QuerySet, databaseType any) (string, error) {
 	return p.columns(querySet.Fields(), databaseType)
@@ -76,51 +113,69 @@ func (p *patcher) columns(fields []accesstypes.Field, databaseType any) (string,
 
 	columns := make([]string, 0, len(columnEntries))
 	for _, c := range columnEntries {
-		columns = append(columns, c.tag)
+		columns = append(columns, p.dialect.QuoteIdentifier(c.tag))
 	}
 
-	switch p.dbType {
-	case spannerdbType:
-		return strings.Join(columns, ", "), nil
-	case postgresdbType:
-		return fmt.Sprintf(`"%s"`, strings.Join(columns, `", "`)), nil
-	default:
-		return "", errors.Newf("unsupported dbType: %s", p.dbType)
-	}
+	return strings.Join(columns, ", "), nil
 }
 
-// Where translates the the fields to database struct tags in databaseType when building the where clause
+// Where translates the the fields to database struct tags in databaseType when building the where clause.
+// When databaseType uses soft deletes, rows which have been deleted are excluded.
 func (p *patcher) Where(keySet resource.KeySet, databaseType any) (where string, params map[string]any, err error) {
+	stmt := newStatement(p.dialect)
+	where, err = p.where(keySet, databaseType, stmt)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return where, paramMap(stmt.params), nil
+}
+
+// whereAny is like Where, but also matches the row when it has been soft deleted
+func (p *patcher) whereAny(keySet resource.KeySet, databaseType any) (where string, params map[string]any, err error) {
+	stmt := newStatement(p.dialect)
+	conditions, err := p.keyConditions(keySet, databaseType, stmt)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return strings.Join(conditions, " AND "), paramMap(stmt.params), nil
+}
+
+// WhereArgs is like Where, but returns the parameters as the query arguments expected by the driver of the Dialect.
+// Use it with dialects having positional placeholders.
+func (p *patcher) WhereArgs(keySet resource.KeySet, databaseType any) (where string, args []any, err error) {
+	stmt := newStatement(p.dialect)
+	where, err = p.where(keySet, databaseType, stmt)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return where, stmt.args(), nil
+}
+
+// keyColumns returns the database struct tags for the keys in keySet
+func (p *patcher) keyColumns(keySet resource.KeySet, databaseType any) ([]string, error) {
 	parts := keySet.Parts()
 	if len(parts) == 0 {
-		return "", nil, errors.New("KeySet must include at least one key in call to Where")
+		return nil, errors.New("KeySet must include at least one key")
 	}
 
 	fieldTagMapping, err := p.get(databaseType)
 	if err != nil {
-		return "", nil, err
+		return nil, err
 	}
 
-	builder := strings.Builder{}
-	params = make(map[string]any, len(parts))
+	columns := make([]string, 0, len(parts))
 	for _, part := range parts {
 		c, ok := fieldTagMapping[part.Key]
 		if !ok {
-			return "", nil, errors.Newf("field %s not found in struct", part.Key)
+			return nil, errors.Newf("field %s not found in struct", part.Key)
 		}
-		key := c.tag
-		switch p.dbType {
-		case spannerdbType:
-			builder.WriteString(fmt.Sprintf(" AND %s = @%s", key, strings.ToLower(key)))
-		case postgresdbType:
-			builder.WriteString(fmt.Sprintf(` AND "%s" = @%s`, key, strings.ToLower(key)))
-		default:
-			return "", nil, errors.Newf("unsupported dbType: %s", p.dbType)
-		}
-		params[strings.ToLower(key)] = part.Value
+		columns = append(columns, c.tag)
 	}
 
-	return builder.String()[5:], params, nil
+	return columns, nil
 }
 
 // Resolve returns a map with the keys set to the database struct tags found on databaseType, and the values set to the values in patchSet.
@@ -189,6 +244,34 @@ func (p *patcher) Diff(old any, patchSet *resource.PatchSet) (map[accesstypes.Fi
 	return diff, nil
 }
 
+// jsonInsertSet returns the ChangeSet of inserting patchSet, or nil when there is nothing to record once the audit options are applied
+func (p *patcher) jsonInsertSet(patchSet *resource.PatchSet, row RowStruct) ([]byte, error) {
+	changeSet, err := p.Diff(row.New(), patchSet)
+	if err != nil {
+		return nil, errors.Wrap(err, "Diff()")
+	}
+
+	if len(changeSet) == 0 {
+		return nil, httpio.NewBadRequestMessage("No data to insert")
+	}
+
+	if err := auditChangeSet(rowStructType(row), changeSet, p.audit.hashKey); err != nil {
+		return nil, err
+	}
+
+	if len(changeSet) == 0 {
+		// Only fields with the audit option omit are set, so there is nothing to record
+		return nil, nil
+	}
+
+	jsonBytes, err := json.Marshal(changeSet)
+	if err != nil {
+		return nil, errors.Wrap(err, "json.Marshal()")
+	}
+
+	return jsonBytes, nil
+}
+
 func (p *patcher) deleteChangeSet(old any) (map[string]DiffElem, error) {
 	oldValue := reflect.ValueOf(old)
 	oldType := reflect.TypeOf(old)
@@ -215,6 +298,10 @@ func (p *patcher) deleteChangeSet(old any) (map[string]DiffElem, error) {
 		}
 	}
 
+	if err := auditChangeSet(oldType, oldMap, p.audit.hashKey); err != nil {
+		return nil, err
+	}
+
 	return oldMap, nil
 }
 
@@ -264,6 +351,10 @@ func all[Map ~map[K]V, K comparable, V any](mapSlice ...Map) iter.Seq2[K, V] {
 	}
 }
 
+// optionsTagName is the struct tag for options which can not be given in the database tag,
+// e.g. `spanner:"Version" patcher:"version"`, since the Spanner client uses the whole tag as the column name.
+const optionsTagName = "patcher"
+
 func structTags(t reflect.Type, key string) map[accesstypes.Field]cacheEntry {
 	tagMap := make(map[accesstypes.Field]cacheEntry)
 	for i := range t.NumField() {
@@ -275,7 +366,13 @@ func structTags(t reflect.Type, key string) map[accesstypes.Field]cacheEntry {
 			continue
 		}
 
-		tagMap[accesstypes.Field(field.Name)] = cacheEntry{index: i, tag: list[0]}
+		options := append(list[1:], strings.Split(field.Tag.Get(optionsTagName), ",")...)
+		tagMap[accesstypes.Field(field.Name)] = cacheEntry{
+			index:      i,
+			tag:        list[0],
+			version:    slices.Contains(options, "version"),
+			softDelete: slices.Contains(options, "softdelete"),
+		}
 	}
 
 	return tagMap
@@ -1,23 +1,30 @@
 package patcher
 
 import (
-	"reflect"
+	"context"
 
 	"github.com/cccteam/ccc/accesstypes"
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/go-playground/errors/v5"
+	"github.com/jackc/pgx/v5"
 )
 
+// PostgresBeginner is implemented by *pgxpool.Pool, *pgx.Conn and pgx.Tx
+type PostgresBeginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+var _ Patcher[PostgresBeginner] = (*PostgresPatcher)(nil)
+
 type PostgresPatcher struct {
-	changeTrackingTable string
-	*patcher
+	*sqlPatcher
 }
 
 func NewPostgresPatcher() *PostgresPatcher {
 	return &PostgresPatcher{
-		changeTrackingTable: "DataChangeEvents",
-		patcher: &patcher{
-			cache:   make(map[reflect.Type]map[accesstypes.Field]cacheEntry),
-			tagName: "db",
-			dbType:  postgresdbType,
+		sqlPatcher: &sqlPatcher{
+			changeTrackingTable: "DataChangeEvents",
+			patcher:             newPatcher("db", PostgresDialect{}),
 		},
 	}
 }
@@ -27,3 +34,240 @@ func (p *PostgresPatcher) WithDataChangeTableName(tableName string) *PostgresPat
 
 	return p
 }
+
+// WithDataChangeEventColumns enables the optional columns of the change tracking table, see DataChangeEventColumn
+func (p *PostgresPatcher) WithDataChangeEventColumns(columns ...DataChangeEventColumn) *PostgresPatcher {
+	p.eventColumns = columns
+
+	return p
+}
+
+// WithTableAudit enables or disables recording the data change events of tableNames, see SpannerPatcher.WithTableAudit
+func (p *PostgresPatcher) WithTableAudit(enabled bool, tableNames ...accesstypes.Resource) *PostgresPatcher {
+	p.audit.setTables(enabled, tableNames)
+
+	return p
+}
+
+// WithAuditHashKey sets the key of the audit option hash, see SpannerPatcher.WithAuditHashKey
+func (p *PostgresPatcher) WithAuditHashKey(key []byte) *PostgresPatcher {
+	p.audit.hashKey = key
+
+	return p
+}
+
+func (p *PostgresPatcher) Insert(ctx context.Context, db PostgresBeginner, mutation *Mutation) error {
+	if err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		if err := p.BufferInsert(ctx, tx, mutation); err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "pgx.BeginFunc()")
+	}
+
+	return nil
+}
+
+func (p *PostgresPatcher) Update(ctx context.Context, db PostgresBeginner, mutation *Mutation) error {
+	if err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		if err := p.BufferUpdate(ctx, tx, mutation); err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "pgx.BeginFunc()")
+	}
+
+	return nil
+}
+
+func (p *PostgresPatcher) InsertOrUpdate(ctx context.Context, db PostgresBeginner, mutation *Mutation) error {
+	if err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		if err := p.BufferInsertOrUpdate(ctx, tx, mutation); err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "pgx.BeginFunc()")
+	}
+
+	return nil
+}
+
+func (p *PostgresPatcher) Delete(ctx context.Context, db PostgresBeginner, mutation *Mutation) error {
+	if err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		if err := p.BufferDelete(ctx, tx, mutation); err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "pgx.BeginFunc()")
+	}
+
+	return nil
+}
+
+func (p *PostgresPatcher) InsertWithDataChangeEvent(ctx context.Context, db PostgresBeginner, eventSource string, mutation *Mutation) error {
+	if err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		if err := p.BufferInsertWithDataChangeEvent(ctx, tx, eventSource, mutation); err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "pgx.BeginFunc()")
+	}
+
+	return nil
+}
+
+func (p *PostgresPatcher) InsertOrUpdateWithDataChangeEvent(ctx context.Context, db PostgresBeginner, eventSource string, mutation *Mutation) error {
+	if err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		if err := p.BufferInsertOrUpdateWithDataChangeEvent(ctx, tx, eventSource, mutation); err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "pgx.BeginFunc()")
+	}
+
+	return nil
+}
+
+func (p *PostgresPatcher) UpdateWithDataChangeEvent(ctx context.Context, db PostgresBeginner, eventSource string, mutation *Mutation) error {
+	if err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		if err := p.BufferUpdateWithDataChangeEvent(ctx, tx, eventSource, mutation); err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "pgx.BeginFunc()")
+	}
+
+	return nil
+}
+
+func (p *PostgresPatcher) DeleteWithDataChangeEvent(ctx context.Context, db PostgresBeginner, eventSource string, mutation *Mutation) error {
+	if err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		if err := p.BufferDeleteWithDataChangeEvent(ctx, tx, eventSource, mutation); err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "pgx.BeginFunc()")
+	}
+
+	return nil
+}
+
+// Mutate applies mutation, along with its data change event, dispatching on its Operation
+func (p *PostgresPatcher) Mutate(ctx context.Context, db PostgresBeginner, eventSource string, mutation *Mutation) error {
+	if err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		if err := p.BufferMutate(ctx, tx, eventSource, mutation); err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "pgx.BeginFunc()")
+	}
+
+	return nil
+}
+
+// Apply applies mutations, along with their data change events, in a single transaction.
+func (p *PostgresPatcher) Apply(ctx context.Context, db PostgresBeginner, eventSource string, mutations ...*Mutation) error {
+	if err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		if err := p.BufferApply(ctx, tx, eventSource, mutations...); err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "pgx.BeginFunc()")
+	}
+
+	return nil
+}
+
+// BufferApply applies mutations, along with their data change events, within tx, dispatching each on its Operation.
+// A failure is returned as a *MutationError identifying the mutation.
+func (p *PostgresPatcher) BufferApply(ctx context.Context, tx pgx.Tx, eventSource string, mutations ...*Mutation) error {
+	return p.apply(ctx, &pgxTx{tx: tx}, eventSource, mutations...)
+}
+
+// BufferMutate applies mutation, along with its data change event, within tx, dispatching on its Operation
+func (p *PostgresPatcher) BufferMutate(ctx context.Context, tx pgx.Tx, eventSource string, mutation *Mutation) error {
+	return p.mutate(ctx, &pgxTx{tx: tx}, eventSource, mutation)
+}
+
+// BufferInsert inserts the row described by mutation within tx. Unlike Spanner, the statement
+// is executed immediately, so it is visible to subsequent statements in tx.
+func (p *PostgresPatcher) BufferInsert(ctx context.Context, tx pgx.Tx, mutation *Mutation) error {
+	return p.insert(ctx, &pgxTx{tx: tx}, mutation)
+}
+
+func (p *PostgresPatcher) BufferUpdate(ctx context.Context, tx pgx.Tx, mutation *Mutation) error {
+	return p.update(ctx, &pgxTx{tx: tx}, mutation)
+}
+
+func (p *PostgresPatcher) BufferInsertOrUpdate(ctx context.Context, tx pgx.Tx, mutation *Mutation) error {
+	return p.insertOrUpdate(ctx, &pgxTx{tx: tx}, mutation)
+}
+
+func (p *PostgresPatcher) BufferDelete(ctx context.Context, tx pgx.Tx, mutation *Mutation) error {
+	return p.delete(ctx, &pgxTx{tx: tx}, mutation)
+}
+
+func (p *PostgresPatcher) BufferInsertWithDataChangeEvent(ctx context.Context, tx pgx.Tx, eventSource string, mutation *Mutation) error {
+	return p.insertWithDataChangeEvent(ctx, &pgxTx{tx: tx}, eventSource, mutation)
+}
+
+// BufferInsertOrUpdateWithDataChangeEvent records an insert ChangeSet when the row does not exist, and an update ChangeSet
+// diffed against the existing row when it does. An upsert changing nothing writes the row without recording a data change event.
+// The version of a versioned row is incremented without being checked, and upserting a soft deleted row fails with a conflict.
+func (p *PostgresPatcher) BufferInsertOrUpdateWithDataChangeEvent(ctx context.Context, tx pgx.Tx, eventSource string, mutation *Mutation) error {
+	return p.insertOrUpdateWithDataChangeEvent(ctx, &pgxTx{tx: tx}, eventSource, mutation)
+}
+
+func (p *PostgresPatcher) BufferUpdateWithDataChangeEvent(ctx context.Context, tx pgx.Tx, eventSource string, mutation *Mutation) error {
+	return p.updateWithDataChangeEvent(ctx, &pgxTx{tx: tx}, eventSource, mutation)
+}
+
+func (p *PostgresPatcher) BufferDeleteWithDataChangeEvent(ctx context.Context, tx pgx.Tx, eventSource string, mutation *Mutation) error {
+	return p.deleteWithDataChangeEvent(ctx, &pgxTx{tx: tx}, eventSource, mutation)
+}
+
+// pgxTx implements sqlTx for pgx.Tx
+type pgxTx struct {
+	tx pgx.Tx
+}
+
+func (t *pgxTx) exec(ctx context.Context, query string, args ...any) (int64, error) {
+	tag, err := t.tx.Exec(ctx, query, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, "pgx.Tx.Exec()")
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+func (t *pgxTx) get(ctx context.Context, dst any, query string, args ...any) error {
+	if err := pgxscan.Get(ctx, t.tx, dst, query, args...); err != nil {
+		if pgxscan.NotFound(err) {
+			return errNotFound
+		}
+
+		return errors.Wrap(err, "pgxscan.Get()")
+	}
+
+	return nil
+}
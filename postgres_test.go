@@ -0,0 +1,183 @@
+package patcher
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cccteam/ccc/accesstypes"
+	"github.com/cccteam/ccc/resource"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// fakePgxTx is an in-process pgx.Tx recording the statements it receives.
+// Queries are answered by rows, which returns the columns and values of the result.
+type fakePgxTx struct {
+	pgx.Tx
+
+	rows func(query string, args []any) (columns []string, values [][]any)
+
+	mu         sync.Mutex
+	statements []string
+	args       [][]any
+	committed  bool
+}
+
+func (t *fakePgxTx) Begin(context.Context) (pgx.Tx, error) {
+	return t, nil
+}
+
+func (t *fakePgxTx) Commit(context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.committed = true
+
+	return nil
+}
+
+func (t *fakePgxTx) Rollback(context.Context) error {
+	return nil
+}
+
+func (t *fakePgxTx) record(query string, args []any) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.statements = append(t.statements, strings.Join(strings.Fields(query), " "))
+	t.args = append(t.args, args)
+}
+
+func (t *fakePgxTx) Exec(_ context.Context, query string, args ...any) (pgconn.CommandTag, error) {
+	t.record(query, args)
+
+	return pgconn.NewCommandTag("UPDATE 1"), nil
+}
+
+func (t *fakePgxTx) Query(_ context.Context, query string, args ...any) (pgx.Rows, error) {
+	t.record(query, args)
+
+	var columns []string
+	var values [][]any
+	if t.rows != nil {
+		columns, values = t.rows(query, args)
+	}
+
+	return &fakePgxRows{columns: columns, values: values}, nil
+}
+
+type fakePgxRows struct {
+	pgx.Rows
+
+	columns []string
+	values  [][]any
+	current []any
+}
+
+func (r *fakePgxRows) Close() {}
+
+func (r *fakePgxRows) Err() error {
+	return nil
+}
+
+func (r *fakePgxRows) CommandTag() pgconn.CommandTag {
+	return pgconn.NewCommandTag("SELECT 1")
+}
+
+func (r *fakePgxRows) FieldDescriptions() []pgconn.FieldDescription {
+	fields := make([]pgconn.FieldDescription, 0, len(r.columns))
+	for _, column := range r.columns {
+		fields = append(fields, pgconn.FieldDescription{Name: column})
+	}
+
+	return fields
+}
+
+func (r *fakePgxRows) Next() bool {
+	if len(r.values) == 0 {
+		return false
+	}
+	r.current, r.values = r.values[0], r.values[1:]
+
+	return true
+}
+
+func (r *fakePgxRows) Scan(dest ...any) error {
+	for i, d := range dest {
+		reflect.ValueOf(d).Elem().Set(reflect.ValueOf(r.current[i]))
+	}
+
+	return nil
+}
+
+func TestPostgresPatcher_UpdateWithDataChangeEvent(t *testing.T) {
+	t.Parallel()
+
+	type Fruit struct {
+		ID    string `db:"Id"`
+		Name  string `db:"Name"`
+		Count int64  `db:"Count"`
+	}
+
+	tx := &fakePgxTx{
+		rows: func(string, []any) ([]string, [][]any) {
+			return []string{"Name"}, [][]any{{"apple"}}
+		},
+	}
+
+	mutation := &Mutation{
+		TableName: "Fruits",
+		RowStruct: NewRowStruct(Fruit{}),
+		PatchSet:  resource.NewPatchSet().Set("Name", "banana"),
+	}
+	mutation.PatchSet.SetKey("ID", "1")
+
+	if err := NewPostgresPatcher().WithDataChangeEventColumns(EventTypeColumn, ChangeSetVersionColumn).UpdateWithDataChangeEvent(context.Background(), tx, "test", mutation); err != nil {
+		t.Fatalf("PostgresPatcher.UpdateWithDataChangeEvent() error = %v", err)
+	}
+
+	want := []string{
+		`SELECT "Name" FROM "Fruits" WHERE "Id" = @id FOR UPDATE`,
+		`UPDATE "Fruits" SET "Id" = @id, "Name" = @name WHERE "Id" = @id_3`,
+		`INSERT INTO "DataChangeEvents" ("ChangeSet", "ChangeSetVersion", "EventSource", "EventTime", "EventType", "RowId", "TableName") ` +
+			`VALUES (@changeset, @changesetversion, @eventsource, CURRENT_TIMESTAMP, @eventtype, @rowid, @tablename)`,
+	}
+	if len(tx.statements) != len(want) {
+		t.Fatalf("statements = %v, want %v", tx.statements, want)
+	}
+	for i := range want {
+		if tx.statements[i] != want[i] {
+			t.Errorf("statements[%d] = (%v),  want (%v)", i, tx.statements[i], want[i])
+		}
+	}
+
+	// Parameters are bound by name with a single pgx.NamedArgs
+	wantArgs := []pgx.NamedArgs{
+		{"id": "1"},
+		{"id": "1", "name": "banana", "id_3": "1"},
+		{
+			"changeset":        `{"Name":{"Old":"apple","New":"banana"}}`,
+			"changesetversion": ChangeSetVersion,
+			"eventsource":      "test",
+			"eventtype":        EventTypeUpdate,
+			"rowid":            "1",
+			"tablename":        accesstypes.Resource("Fruits"),
+		},
+	}
+	for i := range wantArgs {
+		if len(tx.args[i]) != 1 {
+			t.Fatalf("args[%d] = (%v),  want a single pgx.NamedArgs", i, tx.args[i])
+		}
+		got, ok := tx.args[i][0].(pgx.NamedArgs)
+		if !ok || !reflect.DeepEqual(got, wantArgs[i]) {
+			t.Errorf("args[%d] = (%#v),  want (%#v)", i, tx.args[i][0], wantArgs[i])
+		}
+	}
+
+	if !tx.committed {
+		t.Errorf("transaction was not committed")
+	}
+}
@@ -0,0 +1,53 @@
+package patcher
+
+import (
+	"maps"
+	"reflect"
+	"slices"
+
+	"github.com/cccteam/ccc/accesstypes"
+	"github.com/cccteam/ccc/resource"
+	"github.com/cccteam/httpio"
+	"github.com/go-playground/errors/v5"
+)
+
+// updateColumns returns the columns read before an update, the fields in patchSet along with the fields in preconditions
+func (p *patcher) updateColumns(patchSet *resource.PatchSet, preconditions map[accesstypes.Field]any, databaseType any) (string, error) {
+	fields := slices.Clone(patchSet.Fields())
+	for field := range preconditions {
+		if _, ok := patchSet.Data()[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+
+	return p.columns(fields, databaseType)
+}
+
+// checkPreconditions compares preconditions with old, the current row, returning a conflict
+// wrapping a *PreconditionError listing the fields which do not match.
+func checkPreconditions(tableName accesstypes.Resource, keySet resource.KeySet, old any, preconditions map[accesstypes.Field]any) error {
+	oldValue := reflect.Indirect(reflect.ValueOf(old))
+
+	var mismatches []PreconditionMismatch
+	for _, field := range slices.Sorted(maps.Keys(preconditions)) {
+		expected := preconditions[field]
+		actual := oldValue.FieldByName(string(field))
+		if !actual.IsValid() {
+			return errors.Newf("precondition field %s not found in struct", field)
+		}
+
+		if match, err := match(actual.Interface(), expected); err != nil {
+			return err
+		} else if !match {
+			mismatches = append(mismatches, PreconditionMismatch{Field: field, Expected: expected, Actual: actual.Interface()})
+		}
+	}
+
+	if len(mismatches) == 0 {
+		return nil
+	}
+
+	err := &PreconditionError{TableName: tableName, RowID: keySet.RowID(), Mismatches: mismatches}
+
+	return httpio.NewConflictMessageWithError(err, err.Error())
+}
@@ -0,0 +1,152 @@
+package patcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/cccteam/ccc/accesstypes"
+	"github.com/cccteam/ccc/resource"
+	"github.com/cccteam/httpio"
+	"github.com/cccteam/spxscan"
+	"github.com/go-playground/errors/v5"
+)
+
+// Restore recreates the row identified by keySet from the ChangeSet of the delete recorded as its last data change event.
+// The row is recorded in a new data change event with the inverse of the delete ChangeSet.
+// Fields with the audit option redact, hash or omit are not recorded, so they are restored with the default
+// value of their column, or left as is when the table uses soft delete.
+func (p *SpannerPatcher) Restore(
+	ctx context.Context, s *spanner.Client, eventSource string, tableName accesstypes.Resource, row RowStruct, keySet resource.KeySet,
+) error {
+	if _, err := s.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		if err := p.BufferRestore(ctx, txn, eventSource, tableName, row, keySet); err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "spanner.Client.ReadWriteTransaction()")
+	}
+
+	return nil
+}
+
+// BufferRestore recreates the deleted row identified by keySet within txn. See Restore.
+// A soft deleted row is updated to clear its soft delete column, since it was never removed.
+func (p *SpannerPatcher) BufferRestore(
+	ctx context.Context, txn *spanner.ReadWriteTransaction, eventSource string, tableName accesstypes.Resource, row RowStruct, keySet resource.KeySet,
+) error {
+	event, err := p.lastDataChangeEvent(ctx, txn, tableName, keySet.RowID())
+	if err != nil {
+		return err
+	}
+
+	changeSet, err := parseChangeSet(event.ChangeSet)
+	if err != nil {
+		return err
+	}
+
+	eventType, err := event.Type(keySet)
+	if err != nil {
+		return err
+	}
+
+	if eventType != EventTypeDelete {
+		return httpio.NewConflictMessagef("%s (%s) was not deleted by its last change", tableName, keySet.String())
+	}
+
+	return p.bufferRestore(ctx, txn, eventSource, tableName, row, keySet.RowID(), changeSet, nil)
+}
+
+// bufferRestore recreates the row deleted by changeSet, recording the inverse of changeSet in a new data change event
+func (p *SpannerPatcher) bufferRestore(
+	ctx context.Context, txn *spanner.ReadWriteTransaction, eventSource string, tableName accesstypes.Resource, row RowStruct, rowID string, changeSet rawChangeSet, revertedEventTime *time.Time,
+) error {
+	deleted, err := deletedRow(changeSet, row)
+	if err != nil {
+		return err
+	}
+
+	columns, err := p.rowColumns(deleted)
+	if err != nil {
+		return err
+	}
+
+	fieldTagMapping, err := p.get(row.Type())
+	if err != nil {
+		return err
+	}
+
+	// Values which were not recorded are left as is by an update, or to their default by an insert
+	rowType := rowStructType(row)
+	for field, c := range fieldTagMapping {
+		option, err := fieldAudit(rowType, field)
+		if err != nil {
+			return err
+		}
+
+		if _, recorded := changeSet[field]; option == auditOmit || (recorded && isMasked(rowType, field)) {
+			delete(columns, c.tag)
+		}
+	}
+
+	softDelete, err := p.softDelete(row.Type())
+	if err != nil {
+		return err
+	}
+
+	m := spanner.InsertMap(string(tableName), columns)
+	if softDelete != nil {
+		m = spanner.UpdateMap(string(tableName), columns)
+	}
+
+	if err := txn.BufferWrite([]*spanner.Mutation{m}); err != nil {
+		return errors.Wrap(err, "spanner.ReadWriteTransaction.BufferWrite()")
+	}
+
+	jsonChangeSet, err := json.Marshal(changeSet.inverse())
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal()")
+	}
+
+	return p.bufferDataChangeEvent(ctx, txn, &DataChangeEvent{
+		TableName:         tableName,
+		RowID:             rowID,
+		EventSource:       eventSource,
+		EventType:         EventTypeRestore,
+		ChangeSet:         string(jsonChangeSet),
+		RevertedEventTime: revertedEventTime,
+	})
+}
+
+// lastDataChangeEvent reads the most recent data change event recorded for the row identified by rowID
+func (p *SpannerPatcher) lastDataChangeEvent(ctx context.Context, txn *spanner.ReadWriteTransaction, tableName accesstypes.Resource, rowID string) (*DataChangeEvent, error) {
+	stmt := spanner.NewStatement(fmt.Sprintf(`
+			SELECT
+				%s
+			FROM %s
+			WHERE TableName = @tableName AND RowId = @rowId
+			ORDER BY EventTime DESC
+			LIMIT 1`, p.eventColumns.selectList(), p.changeTrackingTable,
+	))
+	stmt.Params["tableName"] = string(tableName)
+	stmt.Params["rowId"] = rowID
+
+	event := &DataChangeEvent{}
+	if err := spxscan.Get(ctx, txn, event, stmt); err != nil {
+		if errors.Is(err, spxscan.ErrNotFound) {
+			return nil, httpio.NewNotFoundMessagef("no data change events for %s (%s)", tableName, rowID)
+		}
+
+		return nil, errors.Wrap(err, "spxscan.Get()")
+	}
+
+	if err := p.DecryptDataChangeEvent(ctx, event); err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
@@ -0,0 +1,350 @@
+package patcher
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/cccteam/ccc/accesstypes"
+	"github.com/cccteam/ccc/resource"
+	"github.com/cccteam/httpio"
+	"github.com/cccteam/spxscan"
+	"github.com/go-playground/errors/v5"
+)
+
+// Revert undoes the change recorded by the data change event of the row identified by keySet at eventTime,
+// recording a new data change event with RevertedEventTime set to eventTime.
+//
+// An update is reverted by writing back its Old values, an insert by deleting the row, and a delete by restoring the row.
+// Revert fails with a conflict when the row has been changed again since the event, or when the event was recorded without
+// its EventType and cannot be told apart from an insert, see DataChangeEvent.Type.
+// Fields with the audit option redact, hash or omit are not recorded, so they are not reverted.
+func (p *SpannerPatcher) Revert(
+	ctx context.Context, s *spanner.Client, eventSource string, tableName accesstypes.Resource, keySet resource.KeySet, row RowStruct, eventTime time.Time,
+) error {
+	if _, err := s.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		if err := p.BufferRevert(ctx, txn, eventSource, tableName, keySet, row, eventTime); err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "spanner.Client.ReadWriteTransaction()")
+	}
+
+	return nil
+}
+
+// BufferRevert undoes the change recorded by the data change event of the row identified by keySet at eventTime within txn.
+// See Revert.
+func (p *SpannerPatcher) BufferRevert(
+	ctx context.Context, txn *spanner.ReadWriteTransaction, eventSource string, tableName accesstypes.Resource, keySet resource.KeySet, row RowStruct, eventTime time.Time,
+) error {
+	plan, err := p.planRevert(ctx, &spannerRevertStore{p: p, txn: txn}, tableName, keySet, row, eventTime)
+	if err != nil {
+		return err
+	}
+
+	switch plan.eventType {
+	case EventTypeRestore:
+		return p.bufferRestore(ctx, txn, eventSource, tableName, row, keySet.RowID(), plan.changeSet, &eventTime)
+	case EventTypeDelete:
+		jsonChangeSet, err := p.jsonDeleteSet(ctx, txn, tableName, keySet, row)
+		if err != nil {
+			return err
+		}
+
+		if err := p.BufferDelete(txn, plan.mutation); err != nil {
+			return err
+		}
+
+		return p.bufferDataChangeEvent(ctx, txn, &DataChangeEvent{
+			TableName:         tableName,
+			RowID:             keySet.RowID(),
+			EventSource:       eventSource,
+			EventType:         EventTypeDelete,
+			ChangeSet:         string(jsonChangeSet),
+			RevertedEventTime: &eventTime,
+		})
+	}
+
+	jsonChangeSet, patchSet, err := p.jsonUpdateSet(ctx, txn, tableName, keySet, plan.mutation.PatchSet, nil, row)
+	if err != nil {
+		return err
+	}
+
+	if err := p.BufferUpdate(txn, plan.mutation.withPatchSet(patchSet)); err != nil {
+		return err
+	}
+
+	if jsonChangeSet == nil {
+		return nil
+	}
+
+	return p.bufferDataChangeEvent(ctx, txn, &DataChangeEvent{
+		TableName:         tableName,
+		RowID:             keySet.RowID(),
+		EventSource:       eventSource,
+		EventType:         EventTypeUpdate,
+		ChangeSet:         string(jsonChangeSet),
+		RevertedEventTime: &eventTime,
+	})
+}
+
+// revertStore reads the data change events and the rows a revert is planned from
+type revertStore interface {
+	// events reads up to limit data change events of the row identified by rowID, most recent first, up to eventTime
+	events(ctx context.Context, tableName accesstypes.Resource, rowID string, eventTime time.Time, limit int64) ([]*DataChangeEvent, error)
+
+	// readRow reads columns of the row identified by keySet into a new row, returning a not found error when it does not exist
+	readRow(ctx context.Context, columns string, tableName accesstypes.Resource, keySet resource.KeySet, row RowStruct) (any, error)
+}
+
+// revertPlan is the change undoing a data change event
+type revertPlan struct {
+	// eventType is the type of the data change event recording the revert: EventTypeRestore when the
+	// reverted event deleted the row, EventTypeDelete when it inserted the row, and EventTypeUpdate otherwise
+	eventType EventType
+
+	// mutation deletes the row, or updates it with the Old values of the reverted event. It is nil for a restore.
+	mutation *Mutation
+
+	// changeSet is the ChangeSet of the reverted event
+	changeSet rawChangeSet
+}
+
+// planRevert plans undoing the data change event of the row identified by keySet at eventTime, checking
+// that the row has not been changed since
+func (p *SpannerPatcher) planRevert(
+	ctx context.Context, store revertStore, tableName accesstypes.Resource, keySet resource.KeySet, row RowStruct, eventTime time.Time,
+) (*revertPlan, error) {
+	events, err := store.events(ctx, tableName, keySet.RowID(), eventTime, 2)
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 || !events[0].EventTime.Equal(eventTime) {
+		return nil, httpio.NewNotFoundMessagef("no data change event for %s (%s) at %s", tableName, keySet.String(), eventTime.Format(time.RFC3339Nano))
+	}
+
+	changeSet, err := parseChangeSet(events[0].ChangeSet)
+	if err != nil {
+		return nil, err
+	}
+
+	eventType, err := events[0].Type(keySet)
+	if err != nil {
+		return nil, err
+	}
+
+	if eventType == EventTypeDelete {
+		if err := p.checkNotExists(ctx, store, tableName, keySet, row); err != nil {
+			return nil, err
+		}
+
+		return &revertPlan{eventType: EventTypeRestore, changeSet: changeSet}, nil
+	}
+
+	current, err := currentValues(changeSet, row)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.checkUnchanged(ctx, store, tableName, keySet, row, current); err != nil {
+		return nil, err
+	}
+
+	inserted := eventType == EventTypeInsert || eventType == EventTypeRestore
+	if eventType == EventTypeUpsert {
+		// The event does not say if the row was inserted. It was when the previous event of the row deleted it, and was not
+		// when the row existed after the previous event. Without a previous event, the row may have been written untracked.
+		if len(events) == 1 {
+			return nil, httpio.NewConflictMessagef("the change of %s (%s) at %s is not known to be an insert or an update", tableName, keySet.String(), eventTime.Format(time.RFC3339Nano))
+		}
+
+		previousType, err := events[1].Type(keySet)
+		if err != nil {
+			return nil, err
+		}
+		inserted = previousType == EventTypeDelete
+	}
+
+	mutation := &Mutation{TableName: tableName, RowStruct: row, PatchSet: resource.NewPatchSet()}
+	for _, part := range keySet.Parts() {
+		mutation.PatchSet.SetKey(part.Key, part.Value)
+	}
+
+	if inserted {
+		mutation.Operation = OperationDelete
+
+		return &revertPlan{eventType: EventTypeDelete, mutation: mutation, changeSet: changeSet}, nil
+	}
+
+	versionField, _, err := p.versionField(row.Type())
+	if err != nil {
+		return nil, err
+	}
+
+	rowType := rowStructType(row)
+	for field, elem := range changeSet {
+		if isMasked(rowType, field) {
+			// The value was not recorded, so the field keeps its current value
+			continue
+		}
+
+		raw := elem.Old
+		if field == versionField {
+			// The version is checked and incremented, never written back
+			raw = elem.New
+		}
+
+		value, err := decodeField(rowType, field, raw)
+		if err != nil {
+			return nil, err
+		}
+		mutation.PatchSet.Set(field, value)
+	}
+	mutation.Operation = OperationUpdate
+
+	return &revertPlan{eventType: EventTypeUpdate, mutation: mutation, changeSet: changeSet}, nil
+}
+
+// currentValues returns the New values of changeSet, which the row still has when it has not been changed since.
+// Masked fields are skipped, since their values were not recorded.
+func currentValues(changeSet rawChangeSet, row RowStruct) (map[accesstypes.Field]any, error) {
+	rowType := rowStructType(row)
+	values := make(map[accesstypes.Field]any, len(changeSet))
+	for field, elem := range changeSet {
+		if isMasked(rowType, field) {
+			continue
+		}
+
+		value, err := decodeField(rowType, field, elem.New)
+		if err != nil {
+			return nil, err
+		}
+		values[field] = value
+	}
+
+	return values, nil
+}
+
+// checkUnchanged returns a conflict when the fields of the row identified by keySet do not have the expected values
+func (p *SpannerPatcher) checkUnchanged(
+	ctx context.Context, store revertStore, tableName accesstypes.Resource, keySet resource.KeySet, row RowStruct, expected map[accesstypes.Field]any,
+) error {
+	columns, err := p.updateColumns(resource.NewPatchSet(), expected, row.Type())
+	if err != nil {
+		return errors.Wrap(err, "patcher.updateColumns()")
+	}
+
+	current, err := store.readRow(ctx, columns, tableName, keySet, row)
+	if err != nil {
+		if httpio.HasNotFound(err) {
+			return httpio.NewConflictMessagef("%s (%s) has been deleted since the change", tableName, keySet.String())
+		}
+
+		return err
+	}
+
+	return checkPreconditions(tableName, keySet, current, expected)
+}
+
+// checkNotExists returns a conflict when the row identified by keySet exists
+func (p *SpannerPatcher) checkNotExists(ctx context.Context, store revertStore, tableName accesstypes.Resource, keySet resource.KeySet, row RowStruct) error {
+	keyFields := make([]accesstypes.Field, 0, keySet.Len())
+	for _, part := range keySet.Parts() {
+		keyFields = append(keyFields, part.Key)
+	}
+
+	columns, err := p.columns(keyFields, row.Type())
+	if err != nil {
+		return err
+	}
+
+	if _, err := store.readRow(ctx, columns, tableName, keySet, row); err != nil {
+		if httpio.HasNotFound(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	return httpio.NewConflictMessagef("%s (%s) has been recreated since the delete", tableName, keySet.String())
+}
+
+// spannerRevertStore is the revertStore reading within the transaction of a revert
+type spannerRevertStore struct {
+	p   *SpannerPatcher
+	txn *spanner.ReadWriteTransaction
+}
+
+func (s *spannerRevertStore) events(ctx context.Context, tableName accesstypes.Resource, rowID string, eventTime time.Time, limit int64) ([]*DataChangeEvent, error) {
+	return s.p.dataChangeEventsUntil(ctx, s.txn, tableName, rowID, eventTime, limit)
+}
+
+func (s *spannerRevertStore) readRow(
+	ctx context.Context, columns string, tableName accesstypes.Resource, keySet resource.KeySet, row RowStruct,
+) (any, error) {
+	return s.p.readRow(ctx, s.txn, columns, tableName, keySet, row)
+}
+
+// readRow reads columns of the row identified by keySet into a new row
+func (p *SpannerPatcher) readRow(
+	ctx context.Context, txn *spanner.ReadWriteTransaction, columns string, tableName accesstypes.Resource, keySet resource.KeySet, row RowStruct,
+) (any, error) {
+	where, params, err := p.Where(keySet, row.Type())
+	if err != nil {
+		return nil, errors.Wrap(err, "patcher.Where()")
+	}
+
+	stmt := spanner.NewStatement(fmt.Sprintf(`
+			SELECT
+				%s
+			FROM %s
+			WHERE %s`, columns, tableName, where,
+	))
+	for param, value := range params {
+		stmt.Params[param] = value
+	}
+
+	v := row.New()
+	if err := spxscan.Get(ctx, txn, v, stmt); err != nil {
+		if errors.Is(err, spxscan.ErrNotFound) {
+			return nil, httpio.NewNotFoundMessagef("%s (%s) not found", tableName, keySet.String())
+		}
+
+		return nil, errors.Wrap(err, "spxscan.Get()")
+	}
+
+	return v, nil
+}
+
+// dataChangeEventsUntil reads up to limit data change events for the row identified by rowID, most recent first, up to eventTime
+func (p *SpannerPatcher) dataChangeEventsUntil(
+	ctx context.Context, txn *spanner.ReadWriteTransaction, tableName accesstypes.Resource, rowID string, eventTime time.Time, limit int64,
+) ([]*DataChangeEvent, error) {
+	stmt := spanner.NewStatement(fmt.Sprintf(`
+			SELECT
+				%s
+			FROM %s
+			WHERE TableName = @tableName AND RowId = @rowId AND EventTime <= @eventTime
+			ORDER BY EventTime DESC
+			LIMIT @limit`, p.eventColumns.selectList(), p.changeTrackingTable,
+	))
+	stmt.Params["tableName"] = string(tableName)
+	stmt.Params["rowId"] = rowID
+	stmt.Params["eventTime"] = eventTime
+	stmt.Params["limit"] = limit
+
+	var events []*DataChangeEvent
+	if err := spxscan.Select(ctx, txn, &events, stmt); err != nil {
+		return nil, errors.Wrap(err, "spxscan.Select()")
+	}
+
+	if err := p.decryptDataChangeEvents(ctx, events); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
@@ -0,0 +1,272 @@
+package patcher
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cccteam/ccc/accesstypes"
+	"github.com/cccteam/ccc/resource"
+	"github.com/cccteam/httpio"
+)
+
+// fakeRevertStore is a revertStore holding the data change events and the current row of a single row in memory
+type fakeRevertStore struct {
+	// recorded are the data change events of the row, most recent first
+	recorded []*DataChangeEvent
+
+	// row is the current row, or nil when it does not exist
+	row any
+}
+
+func (s *fakeRevertStore) events(_ context.Context, _ accesstypes.Resource, _ string, eventTime time.Time, limit int64) ([]*DataChangeEvent, error) {
+	var events []*DataChangeEvent
+	for _, event := range s.recorded {
+		if !event.EventTime.After(eventTime) && int64(len(events)) < limit {
+			events = append(events, event)
+		}
+	}
+
+	return events, nil
+}
+
+func (s *fakeRevertStore) readRow(_ context.Context, _ string, tableName accesstypes.Resource, keySet resource.KeySet, _ RowStruct) (any, error) {
+	if s.row == nil {
+		return nil, httpio.NewNotFoundMessagef("%s (%s) not found", tableName, keySet.String())
+	}
+
+	return s.row, nil
+}
+
+func TestSpannerPatcher_planRevert(t *testing.T) {
+	t.Parallel()
+
+	type Fruit struct {
+		ID    string `spanner:"Id"`
+		Name  string `spanner:"Name"`
+		Count int64  `spanner:"Count"`
+	}
+
+	type VersionedFruit struct {
+		ID      string `spanner:"Id"`
+		Name    string `spanner:"Name"`
+		Version int64  `spanner:"Version" patcher:"version"`
+	}
+
+	t1 := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	t2 := t1.Add(time.Minute)
+
+	tests := []struct {
+		name          string
+		row           RowStruct
+		store         *fakeRevertStore
+		eventTime     time.Time
+		wantEventType EventType
+		wantPatch     map[accesstypes.Field]any
+		wantNotFound  bool
+		wantConflict  bool
+	}{
+		{
+			name: "insert",
+			row:  NewRowStruct(Fruit{}),
+			store: &fakeRevertStore{
+				recorded: []*DataChangeEvent{
+					{EventTime: t1, EventType: EventTypeInsert, ChangeSet: `{"Name":{"Old":"","New":"apple"},"Count":{"Old":0,"New":1}}`},
+				},
+				row: &Fruit{ID: "1", Name: "apple", Count: 1},
+			},
+			eventTime:     t1,
+			wantEventType: EventTypeDelete,
+			wantPatch:     map[accesstypes.Field]any{},
+		},
+		{
+			name: "update",
+			row:  NewRowStruct(Fruit{}),
+			store: &fakeRevertStore{
+				recorded: []*DataChangeEvent{
+					{EventTime: t2, EventType: EventTypeUpdate, ChangeSet: `{"Name":{"Old":"apple","New":"banana"}}`},
+					{EventTime: t1, EventType: EventTypeInsert, ChangeSet: `{"Name":{"Old":"","New":"apple"},"Count":{"Old":0,"New":1}}`},
+				},
+				row: &Fruit{ID: "1", Name: "banana", Count: 1},
+			},
+			eventTime:     t2,
+			wantEventType: EventTypeUpdate,
+			wantPatch:     map[accesstypes.Field]any{"Name": "apple"},
+		},
+		{
+			name: "delete",
+			row:  NewRowStruct(Fruit{}),
+			store: &fakeRevertStore{
+				recorded: []*DataChangeEvent{
+					{EventTime: t2, EventType: EventTypeDelete, ChangeSet: `{"ID":{"Old":"1","New":null},"Name":{"Old":"apple","New":null}}`},
+				},
+			},
+			eventTime:     t2,
+			wantEventType: EventTypeRestore,
+		},
+		{
+			name: "versioned update",
+			row:  NewRowStruct(VersionedFruit{}),
+			store: &fakeRevertStore{
+				recorded: []*DataChangeEvent{
+					{EventTime: t2, EventType: EventTypeUpdate, ChangeSet: `{"Name":{"Old":"apple","New":"banana"},"Version":{"Old":1,"New":2}}`},
+				},
+				row: &VersionedFruit{ID: "1", Name: "banana", Version: 2},
+			},
+			eventTime:     t2,
+			wantEventType: EventTypeUpdate,
+			wantPatch:     map[accesstypes.Field]any{"Name": "apple", "Version": int64(2)},
+		},
+		{
+			name: "versioned update changed since",
+			row:  NewRowStruct(VersionedFruit{}),
+			store: &fakeRevertStore{
+				recorded: []*DataChangeEvent{
+					{EventTime: t2, EventType: EventTypeUpdate, ChangeSet: `{"Name":{"Old":"apple","New":"banana"},"Version":{"Old":1,"New":2}}`},
+				},
+				row: &VersionedFruit{ID: "1", Name: "banana", Version: 3},
+			},
+			eventTime:    t2,
+			wantConflict: true,
+		},
+		{
+			name: "update changed since",
+			row:  NewRowStruct(Fruit{}),
+			store: &fakeRevertStore{
+				recorded: []*DataChangeEvent{
+					{EventTime: t2, EventType: EventTypeUpdate, ChangeSet: `{"Name":{"Old":"apple","New":"banana"}}`},
+				},
+				row: &Fruit{ID: "1", Name: "cherry"},
+			},
+			eventTime:    t2,
+			wantConflict: true,
+		},
+		{
+			name: "update of a row deleted since",
+			row:  NewRowStruct(Fruit{}),
+			store: &fakeRevertStore{
+				recorded: []*DataChangeEvent{
+					{EventTime: t2, EventType: EventTypeUpdate, ChangeSet: `{"Name":{"Old":"apple","New":"banana"}}`},
+				},
+			},
+			eventTime:    t2,
+			wantConflict: true,
+		},
+		{
+			name: "delete of a row recreated since",
+			row:  NewRowStruct(Fruit{}),
+			store: &fakeRevertStore{
+				recorded: []*DataChangeEvent{
+					{EventTime: t2, EventType: EventTypeDelete, ChangeSet: `{"ID":{"Old":"1","New":null},"Name":{"Old":"apple","New":null}}`},
+				},
+				row: &Fruit{ID: "1", Name: "apple"},
+			},
+			eventTime:    t2,
+			wantConflict: true,
+		},
+		{
+			name: "untyped update",
+			row:  NewRowStruct(Fruit{}),
+			store: &fakeRevertStore{
+				recorded: []*DataChangeEvent{
+					{EventTime: t2, ChangeSet: `{"Name":{"Old":"apple","New":"banana"}}`},
+				},
+				row: &Fruit{ID: "1", Name: "banana"},
+			},
+			eventTime:     t2,
+			wantEventType: EventTypeUpdate,
+			wantPatch:     map[accesstypes.Field]any{"Name": "apple"},
+		},
+		{
+			name: "untyped first change from zero values",
+			row:  NewRowStruct(Fruit{}),
+			store: &fakeRevertStore{
+				recorded: []*DataChangeEvent{
+					{EventTime: t2, ChangeSet: `{"Count":{"Old":0,"New":5}}`},
+				},
+				row: &Fruit{ID: "1", Name: "apple", Count: 5},
+			},
+			eventTime:    t2,
+			wantConflict: true,
+		},
+		{
+			name: "untyped change after an update",
+			row:  NewRowStruct(Fruit{}),
+			store: &fakeRevertStore{
+				recorded: []*DataChangeEvent{
+					{EventTime: t2, ChangeSet: `{"Count":{"Old":0,"New":5}}`},
+					{EventTime: t1, ChangeSet: `{"Name":{"Old":"","New":"apple"}}`},
+				},
+				row: &Fruit{ID: "1", Name: "apple", Count: 5},
+			},
+			eventTime:     t2,
+			wantEventType: EventTypeUpdate,
+			wantPatch:     map[accesstypes.Field]any{"Count": int64(0)},
+		},
+		{
+			name: "untyped change after a delete",
+			row:  NewRowStruct(Fruit{}),
+			store: &fakeRevertStore{
+				recorded: []*DataChangeEvent{
+					{EventTime: t2, ChangeSet: `{"Name":{"Old":"","New":"apple"}}`},
+					{EventTime: t1, ChangeSet: `{"ID":{"Old":"1","New":null},"Name":{"Old":"cherry","New":null}}`},
+				},
+				row: &Fruit{ID: "1", Name: "apple"},
+			},
+			eventTime:     t2,
+			wantEventType: EventTypeDelete,
+			wantPatch:     map[accesstypes.Field]any{},
+		},
+		{
+			name: "no event at eventTime",
+			row:  NewRowStruct(Fruit{}),
+			store: &fakeRevertStore{
+				recorded: []*DataChangeEvent{
+					{EventTime: t1, EventType: EventTypeInsert, ChangeSet: `{"Name":{"Old":"","New":"apple"}}`},
+				},
+				row: &Fruit{ID: "1", Name: "apple"},
+			},
+			eventTime:    t2,
+			wantNotFound: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			keySet := resource.NewKeySet("ID", "1")
+			plan, err := NewSpannerPatcher().planRevert(context.Background(), tt.store, "Fruits", keySet, tt.row, tt.eventTime)
+			if tt.wantNotFound || tt.wantConflict {
+				if httpio.HasNotFound(err) != tt.wantNotFound || httpio.HasConflict(err) != tt.wantConflict {
+					t.Fatalf("SpannerPatcher.planRevert() error = %v, wantNotFound %v, wantConflict %v", err, tt.wantNotFound, tt.wantConflict)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("SpannerPatcher.planRevert() error = %v", err)
+			}
+
+			if plan.eventType != tt.wantEventType {
+				t.Errorf("revertPlan.eventType = (%v),  want (%v)", plan.eventType, tt.wantEventType)
+			}
+
+			if tt.wantEventType == EventTypeRestore {
+				if plan.mutation != nil || len(plan.changeSet) == 0 {
+					t.Errorf("revertPlan = (%v),  want the ChangeSet of the delete without a mutation", plan)
+				}
+
+				return
+			}
+
+			if got := plan.mutation.PatchSet.KeySet().RowID(); got != keySet.RowID() {
+				t.Errorf("revertPlan.mutation keys = (%v),  want (%v)", got, keySet.RowID())
+			}
+			if got := plan.mutation.PatchSet.Data(); !reflect.DeepEqual(got, tt.wantPatch) {
+				t.Errorf("revertPlan.mutation.PatchSet = (%v),  want (%v)", got, tt.wantPatch)
+			}
+		})
+	}
+}
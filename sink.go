@@ -0,0 +1,117 @@
+package patcher
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"cloud.google.com/go/spanner"
+	"github.com/cccteam/logger"
+	"github.com/go-playground/errors/v5"
+)
+
+// ChangeEventSink receives each DataChangeEvent recorded by the SpannerPatcher, within the transaction making the change.
+// The event must not be modified by the sink.
+type ChangeEventSink interface {
+	BufferDataChangeEvent(ctx context.Context, txn *spanner.ReadWriteTransaction, event *DataChangeEvent) error
+}
+
+var (
+	_ ChangeEventSink = (*SpannerTableSink)(nil)
+	_ ChangeEventSink = (*MemorySink)(nil)
+	_ ChangeEventSink = (*LogSink)(nil)
+)
+
+// SpannerTableSink buffers data change events into a change tracking table, with EventTime set to the commit timestamp.
+// Optional columns are only written once they are enabled with WithDataChangeEventColumns, see DataChangeEventColumn.
+//
+// It is the sink the SpannerPatcher always writes to, using the table set with WithDataChangeTableName.
+type SpannerTableSink struct {
+	tableName    string
+	eventColumns eventColumns
+	*patcher
+}
+
+// NewSpannerTableSink returns a sink writing data change events into tableName
+func NewSpannerTableSink(tableName string) *SpannerTableSink {
+	return &SpannerTableSink{
+		tableName: tableName,
+		patcher:   newPatcher("spanner", SpannerDialect{}),
+	}
+}
+
+// WithDataChangeEventColumns enables the optional columns of the change tracking table, see DataChangeEventColumn
+func (s *SpannerTableSink) WithDataChangeEventColumns(columns ...DataChangeEventColumn) *SpannerTableSink {
+	s.eventColumns = columns
+
+	return s
+}
+
+func (s *SpannerTableSink) BufferDataChangeEvent(_ context.Context, txn *spanner.ReadWriteTransaction, event *DataChangeEvent) error {
+	columns, err := s.dataChangeEventPatch(event, spanner.CommitTimestamp, s.eventColumns)
+	if err != nil {
+		return err
+	}
+
+	if err := txn.BufferWrite([]*spanner.Mutation{spanner.InsertMap(s.tableName, columns)}); err != nil {
+		return errors.Wrap(err, "spanner.ReadWriteTransaction.BufferWrite()")
+	}
+
+	return nil
+}
+
+// MemorySink collects data change events in memory, which is mostly useful in tests.
+//
+// Events are collected when they are buffered, so events of transactions which are retried or
+// fail to commit are collected too, and their EventTime is not set.
+type MemorySink struct {
+	mu     sync.Mutex
+	events []DataChangeEvent
+}
+
+// NewMemorySink returns an empty MemorySink
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+func (s *MemorySink) BufferDataChangeEvent(_ context.Context, _ *spanner.ReadWriteTransaction, event *DataChangeEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = append(s.events, *event)
+
+	return nil
+}
+
+// Events returns the collected events, in the order they were buffered
+func (s *MemorySink) Events() []DataChangeEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.events)
+}
+
+// Reset discards the collected events
+func (s *MemorySink) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = nil
+}
+
+// LogSink logs data change events with the logger of the context.
+//
+// Events are logged when they are buffered, so events of transactions which are retried or
+// fail to commit are logged too, and their EventTime is not set.
+type LogSink struct{}
+
+// NewLogSink returns a LogSink
+func NewLogSink() *LogSink {
+	return &LogSink{}
+}
+
+func (s *LogSink) BufferDataChangeEvent(ctx context.Context, _ *spanner.ReadWriteTransaction, event *DataChangeEvent) error {
+	logger.Ctx(ctx).Infof("data change event: %s %s (%s) by %s: %s", event.EventType, event.TableName, event.RowID, event.EventSource, event.ChangeSet)
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package patcher
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestMemorySink(t *testing.T) {
+	t.Parallel()
+
+	events := []*DataChangeEvent{
+		{TableName: "Fruits", RowID: "1", EventType: EventTypeInsert, ChangeSet: `{"Name":{"Old":"","New":"apple"}}`},
+		{TableName: "Fruits", RowID: "1", EventType: EventTypeUpdate, ChangeSet: `{"Name":{"Old":"apple","New":"pear"}}`},
+	}
+
+	sink := NewMemorySink()
+	for _, event := range events {
+		if err := sink.BufferDataChangeEvent(context.Background(), nil, event); err != nil {
+			t.Fatalf("MemorySink.BufferDataChangeEvent() error = %v", err)
+		}
+	}
+
+	// Events are copied, so later changes to the buffered event are not collected
+	events[0].RowID = "2"
+
+	want := []DataChangeEvent{
+		{TableName: "Fruits", RowID: "1", EventType: EventTypeInsert, ChangeSet: `{"Name":{"Old":"","New":"apple"}}`},
+		{TableName: "Fruits", RowID: "1", EventType: EventTypeUpdate, ChangeSet: `{"Name":{"Old":"apple","New":"pear"}}`},
+	}
+	if got := sink.Events(); !reflect.DeepEqual(got, want) {
+		t.Errorf("MemorySink.Events() = (%v),  want (%v)", got, want)
+	}
+
+	sink.Reset()
+	if got := sink.Events(); len(got) != 0 {
+		t.Errorf("MemorySink.Events() = (%v),  want (%v)", got, nil)
+	}
+}
@@ -0,0 +1,96 @@
+package patcher
+
+import (
+	"reflect"
+
+	"cloud.google.com/go/spanner"
+	"github.com/cccteam/ccc/accesstypes"
+)
+
+// softDelete is the column marking the rows of a type as deleted, tagged with the softdelete option,
+// e.g. `db:"DeletedAt,softdelete"` or `spanner:"DeletedAt" patcher:"softdelete"`.
+// The column is either a nullable timestamp, set to the commit timestamp, or a bool, set to true.
+type softDelete struct {
+	field accesstypes.Field
+	tag   string
+	flag  bool
+}
+
+// softDelete returns the soft delete column of databaseType, or nil when its rows are deleted
+func (p *patcher) softDelete(databaseType any) (*softDelete, error) {
+	fieldTagMapping, err := p.get(databaseType)
+	if err != nil {
+		return nil, err
+	}
+
+	t := reflect.TypeOf(databaseType)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	for field, c := range fieldTagMapping {
+		if !c.softDelete {
+			continue
+		}
+
+		fieldType := t.Field(c.index).Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+
+		return &softDelete{
+			field: field,
+			tag:   c.tag,
+			flag:  fieldType.Kind() == reflect.Bool || fieldType == reflect.TypeOf(spanner.NullBool{}),
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// NotDeleted returns the condition excluding the soft deleted rows of databaseType, for queries which are
+// not built with Where, or an empty string when databaseType does not use soft deletes.
+func (p *patcher) NotDeleted(databaseType any) (string, error) {
+	s, err := p.softDelete(databaseType)
+	if err != nil {
+		return "", err
+	}
+	if s == nil {
+		return "", nil
+	}
+
+	return s.condition(p.dialect), nil
+}
+
+// condition returns the condition matching rows which have not been deleted
+func (s *softDelete) condition(dialect Dialect) string {
+	if s.flag {
+		return dialect.QuoteIdentifier(s.tag) + " IS NOT TRUE"
+	}
+
+	return dialect.QuoteIdentifier(s.tag) + " IS NULL"
+}
+
+// deleted reports whether old, a row read with the soft delete column, has been deleted
+func (s *softDelete) deleted(old any) bool {
+	v := reflect.Indirect(reflect.ValueOf(old)).FieldByName(string(s.field))
+	if !s.flag {
+		return !v.IsZero()
+	}
+
+	if b, ok := v.Interface().(spanner.NullBool); ok {
+		return b.Valid && b.Bool
+	}
+	v = reflect.Indirect(v)
+
+	return v.IsValid() && v.Bool()
+}
+
+// value returns the value marking a row as deleted, given the commit timestamp of the backend
+func (s *softDelete) value(commitTimestamp any) any {
+	if s.flag {
+		return true
+	}
+
+	return commitTimestamp
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"reflect"
+	"maps"
 
 	"cloud.google.com/go/spanner"
 	"github.com/cccteam/ccc/accesstypes"
@@ -14,19 +14,20 @@ import (
 	"github.com/go-playground/errors/v5"
 )
 
+var _ Patcher[*spanner.Client] = (*SpannerPatcher)(nil)
+
 type SpannerPatcher struct {
 	changeTrackingTable string
+	eventColumns        eventColumns
+	sinks               []ChangeEventSink
+	encryption          *changeSetEncryption
 	*patcher
 }
 
 func NewSpannerPatcher() *SpannerPatcher {
 	return &SpannerPatcher{
 		changeTrackingTable: "DataChangeEvents",
-		patcher: &patcher{
-			cache:   make(map[reflect.Type]map[accesstypes.Field]cacheEntry),
-			tagName: "spanner",
-			dbType:  spannerdbType,
-		},
+		patcher:             newPatcher("spanner", SpannerDialect{}),
 	}
 }
 
@@ -36,6 +37,38 @@ func (p *SpannerPatcher) WithDataChangeTableName(tableName string) *SpannerPatch
 	return p
 }
 
+// WithDataChangeEventColumns enables the optional columns of the change tracking table, which are only written and read
+// once enabled, see DataChangeEventColumn
+func (p *SpannerPatcher) WithDataChangeEventColumns(columns ...DataChangeEventColumn) *SpannerPatcher {
+	p.eventColumns = columns
+
+	return p
+}
+
+// WithTableAudit enables or disables recording the data change events of tableNames, or of all tables when none are given.
+// Tables are audited by default. The rows of tables which are not audited are still written by the WithDataChangeEvent
+// methods, with their versions and preconditions checked, but no data change event is recorded or passed to the sinks.
+func (p *SpannerPatcher) WithTableAudit(enabled bool, tableNames ...accesstypes.Resource) *SpannerPatcher {
+	p.audit.setTables(enabled, tableNames)
+
+	return p
+}
+
+// WithAuditHashKey sets the key of the HMAC recorded for fields with the audit option hash, which fail to be recorded without it
+func (p *SpannerPatcher) WithAuditHashKey(key []byte) *SpannerPatcher {
+	p.audit.hashKey = key
+
+	return p
+}
+
+// WithChangeEventSink registers sink to receive each data change event, in addition to the change tracking table.
+// Sinks are called in the order they are registered, and an error from a sink fails the change.
+func (p *SpannerPatcher) WithChangeEventSink(sink ChangeEventSink) *SpannerPatcher {
+	p.sinks = append(p.sinks, sink)
+
+	return p
+}
+
 func (p *SpannerPatcher) Insert(ctx context.Context, s *spanner.Client, mutation *Mutation) error {
 	if _, err := s.ReadWriteTransaction(ctx, func(_ context.Context, txn *spanner.ReadWriteTransaction) error {
 		if err := p.BufferInsert(txn, mutation); err != nil {
@@ -94,7 +127,7 @@ func (p *SpannerPatcher) Delete(ctx context.Context, s *spanner.Client, mutation
 
 func (p *SpannerPatcher) InsertWithDataChangeEvent(ctx context.Context, s *spanner.Client, eventSource string, mutation *Mutation) error {
 	if _, err := s.ReadWriteTransaction(ctx, func(_ context.Context, txn *spanner.ReadWriteTransaction) error {
-		if err := p.BufferInsertWithDataChangeEvent(txn, eventSource, mutation); err != nil {
+		if err := p.BufferInsertWithDataChangeEvent(ctx, txn, eventSource, mutation); err != nil {
 			return err
 		}
 
@@ -108,7 +141,7 @@ func (p *SpannerPatcher) InsertWithDataChangeEvent(ctx context.Context, s *spann
 
 func (p *SpannerPatcher) InsertOrUpdateWithDataChangeEvent(ctx context.Context, s *spanner.Client, eventSource string, mutation *Mutation) error {
 	if _, err := s.ReadWriteTransaction(ctx, func(_ context.Context, txn *spanner.ReadWriteTransaction) error {
-		if err := p.BufferInsertOrUpdateWithDataChangeEvent(txn, eventSource, mutation); err != nil {
+		if err := p.BufferInsertOrUpdateWithDataChangeEvent(ctx, txn, eventSource, mutation); err != nil {
 			return err
 		}
 
@@ -148,6 +181,94 @@ func (p *SpannerPatcher) DeleteWithDataChangeEvent(ctx context.Context, s *spann
 	return nil
 }
 
+// Mutate applies mutation, along with its data change event, dispatching on its Operation
+func (p *SpannerPatcher) Mutate(ctx context.Context, s *spanner.Client, eventSource string, mutation *Mutation) error {
+	if _, err := s.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		if err := p.BufferMutate(ctx, txn, eventSource, mutation); err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "spanner.Client.ReadWriteTransaction()")
+	}
+
+	return nil
+}
+
+// Apply applies mutations, along with their data change events, in a single transaction.
+func (p *SpannerPatcher) Apply(ctx context.Context, s *spanner.Client, eventSource string, mutations ...*Mutation) error {
+	if _, err := s.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		if err := p.BufferApply(ctx, txn, eventSource, mutations...); err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "spanner.Client.ReadWriteTransaction()")
+	}
+
+	return nil
+}
+
+// BufferApply buffers mutations, along with their data change events, dispatching each on its Operation.
+// A failure is returned as a *MutationError identifying the mutation.
+//
+// Buffered writes are not visible to reads in the same transaction, and the data change events of a row
+// mutated twice would have the same EventTime, so mutating a row more than once is rejected before anything is buffered.
+func (p *SpannerPatcher) BufferApply(ctx context.Context, txn *spanner.ReadWriteTransaction, eventSource string, mutations ...*Mutation) error {
+	if err := checkDistinctRows(mutations); err != nil {
+		return err
+	}
+
+	for i, mutation := range mutations {
+		if err := p.BufferMutate(ctx, txn, eventSource, mutation); err != nil {
+			return &MutationError{Index: i, Mutation: mutation, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// checkDistinctRows returns a *MutationError for the first mutation of a row already mutated earlier in mutations
+func checkDistinctRows(mutations []*Mutation) error {
+	type row struct {
+		tableName accesstypes.Resource
+		rowID     string
+	}
+
+	seen := make(map[row]int, len(mutations))
+	for i, mutation := range mutations {
+		r := row{tableName: mutation.TableName, rowID: mutation.PatchSet.KeySet().RowID()}
+		if first, ok := seen[r]; ok {
+			return &MutationError{
+				Index:    i,
+				Mutation: mutation,
+				Err:      httpio.NewBadRequestMessagef("%s (%s) is already mutated by mutation %d", r.tableName, mutation.PatchSet.KeySet().String(), first),
+			}
+		}
+		seen[r] = i
+	}
+
+	return nil
+}
+
+// BufferMutate buffers mutation, along with its data change event, dispatching on its Operation
+func (p *SpannerPatcher) BufferMutate(ctx context.Context, txn *spanner.ReadWriteTransaction, eventSource string, mutation *Mutation) error {
+	switch mutation.Operation {
+	case OperationCreate:
+		return p.BufferInsertWithDataChangeEvent(ctx, txn, eventSource, mutation)
+	case OperationUpdate:
+		return p.BufferUpdateWithDataChangeEvent(ctx, txn, eventSource, mutation)
+	case OperationUpsert:
+		return p.BufferInsertOrUpdateWithDataChangeEvent(ctx, txn, eventSource, mutation)
+	case OperationDelete:
+		return p.BufferDeleteWithDataChangeEvent(ctx, txn, eventSource, mutation)
+	default:
+		return errors.Newf("unsupported operation %q", mutation.Operation)
+	}
+}
+
 func (p *SpannerPatcher) BufferInsert(txn *spanner.ReadWriteTransaction, mutation *Mutation) error {
 	patch, err := p.Resolve(mutation.PatchSet, mutation.RowStruct.Type())
 	if err != nil {
@@ -190,8 +311,27 @@ func (p *SpannerPatcher) BufferInsertOrUpdate(txn *spanner.ReadWriteTransaction,
 	return nil
 }
 
+// BufferDelete deletes the row, or sets its soft delete column when the RowStruct has one. A soft delete
+// timestamp is set to the commit timestamp, so the column must allow it (allow_commit_timestamp=true).
 func (p *SpannerPatcher) BufferDelete(txn *spanner.ReadWriteTransaction, mutation *Mutation) error {
+	softDelete, err := p.softDelete(mutation.RowStruct.Type())
+	if err != nil {
+		return err
+	}
+
 	m := spanner.Delete(string(mutation.TableName), mutation.PatchSet.KeySet().KeySet())
+	if softDelete != nil {
+		patchSet := resource.NewPatchSet().Set(softDelete.field, softDelete.value(spanner.CommitTimestamp))
+		for _, part := range mutation.PatchSet.KeySet().Parts() {
+			patchSet.SetKey(part.Key, part.Value)
+		}
+
+		patch, err := p.Resolve(patchSet, mutation.RowStruct.Type())
+		if err != nil {
+			return errors.Wrap(err, "Resolve()")
+		}
+		m = spanner.UpdateMap(string(mutation.TableName), patch)
+	}
 
 	if err := txn.BufferWrite([]*spanner.Mutation{m}); err != nil {
 		return errors.Wrap(err, "spanner.ReadWriteTransaction.BufferWrite()")
@@ -200,36 +340,64 @@ func (p *SpannerPatcher) BufferDelete(txn *spanner.ReadWriteTransaction, mutatio
 	return nil
 }
 
-func (p *SpannerPatcher) BufferInsertWithDataChangeEvent(txn *spanner.ReadWriteTransaction, eventSource string, mutation *Mutation) error {
+func (p *SpannerPatcher) BufferInsertWithDataChangeEvent(ctx context.Context, txn *spanner.ReadWriteTransaction, eventSource string, mutation *Mutation) error {
 	if err := p.BufferInsert(txn, mutation); err != nil {
 		return err
 	}
 
-	if err := p.bufferInsertWithDataChangeEvent(txn, eventSource, mutation); err != nil {
+	if err := p.bufferInsertWithDataChangeEvent(ctx, txn, eventSource, mutation); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (p *SpannerPatcher) BufferInsertOrUpdateWithDataChangeEvent(txn *spanner.ReadWriteTransaction, eventSource string, mutation *Mutation) error {
-	if err := p.BufferInsertOrUpdate(txn, mutation); err != nil {
+// BufferInsertOrUpdateWithDataChangeEvent reads the existing row within txn, recording an insert ChangeSet when
+// the row does not exist, and an update ChangeSet diffed against the existing row when it does. An upsert changing
+// nothing writes the row without recording a data change event. The version of a versioned row is incremented
+// without being checked, and upserting a soft deleted row fails with a conflict.
+func (p *SpannerPatcher) BufferInsertOrUpdateWithDataChangeEvent(ctx context.Context, txn *spanner.ReadWriteTransaction, eventSource string, mutation *Mutation) error {
+	keySet := mutation.PatchSet.KeySet()
+	old, err := p.upsertRow(ctx, txn, mutation)
+	if err != nil {
+		return err
+	}
+
+	eventType, jsonChangeSet, patchSet, err := p.jsonUpsertSet(mutation.TableName, keySet, old, mutation.PatchSet, mutation.Preconditions, mutation.RowStruct)
+	if err != nil {
 		return err
 	}
 
-	if err := p.bufferInsertWithDataChangeEvent(txn, eventSource, mutation); err != nil {
+	if err := p.BufferInsertOrUpdate(txn, mutation.withPatchSet(patchSet)); err != nil {
+		return err
+	}
+
+	if jsonChangeSet == nil {
+		return nil
+	}
+
+	if err := p.bufferDataChangeEvent(ctx, txn, &DataChangeEvent{
+		TableName:   mutation.TableName,
+		RowID:       keySet.RowID(),
+		EventSource: eventSource,
+		EventType:   eventType,
+		ChangeSet:   string(jsonChangeSet),
+	}); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// BufferUpdateWithDataChangeEvent buffers the update and its data change event. When the RowStruct has a
+// version field, the version in the PatchSet must match the row, and is incremented by the update.
 func (p *SpannerPatcher) BufferUpdateWithDataChangeEvent(ctx context.Context, txn *spanner.ReadWriteTransaction, eventSource string, mutation *Mutation) error {
-	if err := p.BufferUpdate(txn, mutation); err != nil {
+	patchSet, err := p.bufferUpdateWithDataChangeEvent(ctx, txn, eventSource, mutation)
+	if err != nil {
 		return err
 	}
 
-	if err := p.bufferUpdateWithDataChangeEvent(ctx, txn, eventSource, mutation); err != nil {
+	if err := p.BufferUpdate(txn, mutation.withPatchSet(patchSet)); err != nil {
 		return err
 	}
 
@@ -248,57 +416,54 @@ func (p *SpannerPatcher) BufferDeleteWithDataChangeEvent(ctx context.Context, tx
 	return nil
 }
 
-func (p *SpannerPatcher) bufferInsertWithDataChangeEvent(txn *spanner.ReadWriteTransaction, eventSource string, mutation *Mutation) error {
+func (p *SpannerPatcher) bufferInsertWithDataChangeEvent(ctx context.Context, txn *spanner.ReadWriteTransaction, eventSource string, mutation *Mutation) error {
 	jsonChangeSet, err := p.jsonInsertSet(mutation.PatchSet, mutation.RowStruct)
 	if err != nil {
 		return err
 	}
 
-	m, err := spanner.InsertStruct(p.changeTrackingTable,
-		&DataChangeEvent{
-			TableName:   mutation.TableName,
-			RowID:       mutation.PatchSet.KeySet().RowID(),
-			EventTime:   spanner.CommitTimestamp,
-			EventSource: eventSource,
-			ChangeSet:   string(jsonChangeSet),
-		},
-	)
-	if err != nil {
-		return errors.Wrap(err, "spanner.InsertStruct()")
+	if jsonChangeSet == nil {
+		return nil
 	}
 
-	if err := txn.BufferWrite([]*spanner.Mutation{m}); err != nil {
-		return errors.Wrap(err, "spanner.ReadWriteTransaction.BufferWrite()")
+	if err := p.bufferDataChangeEvent(ctx, txn, &DataChangeEvent{
+		TableName:   mutation.TableName,
+		RowID:       mutation.PatchSet.KeySet().RowID(),
+		EventSource: eventSource,
+		EventType:   EventTypeInsert,
+		ChangeSet:   string(jsonChangeSet),
+	}); err != nil {
+		return err
 	}
 
 	return nil
 }
 
-func (p *SpannerPatcher) bufferUpdateWithDataChangeEvent(ctx context.Context, txn *spanner.ReadWriteTransaction, eventSource string, mutation *Mutation) error {
+// bufferUpdateWithDataChangeEvent buffers the data change event for mutation, returning the PatchSet to update the row with
+func (p *SpannerPatcher) bufferUpdateWithDataChangeEvent(
+	ctx context.Context, txn *spanner.ReadWriteTransaction, eventSource string, mutation *Mutation,
+) (*resource.PatchSet, error) {
 	keySet := mutation.PatchSet.KeySet()
-	jsonChangeSet, err := p.jsonUpdateSet(ctx, txn, mutation.TableName, keySet, mutation.PatchSet, mutation.RowStruct)
+	jsonChangeSet, patchSet, err := p.jsonUpdateSet(ctx, txn, mutation.TableName, keySet, mutation.PatchSet, mutation.Preconditions, mutation.RowStruct)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	m, err := spanner.InsertStruct(p.changeTrackingTable,
-		&DataChangeEvent{
-			TableName:   mutation.TableName,
-			RowID:       keySet.RowID(),
-			EventTime:   spanner.CommitTimestamp,
-			EventSource: eventSource,
-			ChangeSet:   string(jsonChangeSet),
-		},
-	)
-	if err != nil {
-		return errors.Wrap(err, "spanner.InsertStruct()")
+	if jsonChangeSet == nil {
+		return patchSet, nil
 	}
 
-	if err := txn.BufferWrite([]*spanner.Mutation{m}); err != nil {
-		return errors.Wrap(err, "spanner.ReadWriteTransaction.BufferWrite()")
+	if err := p.bufferDataChangeEvent(ctx, txn, &DataChangeEvent{
+		TableName:   mutation.TableName,
+		RowID:       keySet.RowID(),
+		EventSource: eventSource,
+		EventType:   EventTypeUpdate,
+		ChangeSet:   string(jsonChangeSet),
+	}); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return patchSet, nil
 }
 
 func (p *SpannerPatcher) bufferDeleteWithDataChangeEvent(ctx context.Context, txn *spanner.ReadWriteTransaction, eventSource string, mutation *Mutation) error {
@@ -308,55 +473,62 @@ func (p *SpannerPatcher) bufferDeleteWithDataChangeEvent(ctx context.Context, tx
 		return err
 	}
 
-	m, err := spanner.InsertStruct(p.changeTrackingTable,
-		&DataChangeEvent{
-			TableName:   mutation.TableName,
-			RowID:       keySet.RowID(),
-			EventTime:   spanner.CommitTimestamp,
-			EventSource: eventSource,
-			ChangeSet:   string(jsonChangeSet),
-		},
-	)
-	if err != nil {
-		return errors.Wrap(err, "spanner.InsertStruct()")
-	}
-
-	if err := txn.BufferWrite([]*spanner.Mutation{m}); err != nil {
-		return errors.Wrap(err, "spanner.ReadWriteTransaction.BufferWrite()")
+	if err := p.bufferDataChangeEvent(ctx, txn, &DataChangeEvent{
+		TableName:   mutation.TableName,
+		RowID:       keySet.RowID(),
+		EventSource: eventSource,
+		EventType:   EventTypeDelete,
+		ChangeSet:   string(jsonChangeSet),
+	}); err != nil {
+		return err
 	}
 
 	return nil
 }
 
-func (p *SpannerPatcher) jsonInsertSet(patchSet *resource.PatchSet, row RowStruct) ([]byte, error) {
-	changeSet, err := p.Diff(row.New(), patchSet)
-	if err != nil {
-		return nil, errors.Wrap(err, "Diff()")
+// bufferDataChangeEvent buffers event into the change tracking table, and passes it to the registered sinks.
+// The ChangeSet is encrypted first when encryption is enabled for the table. Events of tables which are
+// not audited are dropped, see WithTableAudit.
+func (p *SpannerPatcher) bufferDataChangeEvent(ctx context.Context, txn *spanner.ReadWriteTransaction, event *DataChangeEvent) error {
+	if !p.audit.audited(event.TableName) {
+		return nil
 	}
 
-	if len(changeSet) == 0 {
-		return nil, httpio.NewBadRequestMessage("No data to insert")
+	event.ChangeSetVersion = ChangeSetVersion
+	event.setFromContext(ctx)
+
+	if err := p.encryptDataChangeEvent(ctx, event); err != nil {
+		return err
 	}
 
-	jsonBytes, err := json.Marshal(changeSet)
-	if err != nil {
-		return nil, errors.Wrap(err, "json.Marshal()")
+	tableSink := &SpannerTableSink{tableName: p.changeTrackingTable, eventColumns: p.eventColumns, patcher: p.patcher}
+	if err := tableSink.BufferDataChangeEvent(ctx, txn, event); err != nil {
+		return err
 	}
 
-	return jsonBytes, nil
+	for _, sink := range p.sinks {
+		if err := sink.BufferDataChangeEvent(ctx, txn, event); err != nil {
+			return errors.Wrap(err, "ChangeEventSink.BufferDataChangeEvent()")
+		}
+	}
+
+	return nil
 }
 
+// jsonUpdateSet returns the ChangeSet of updating the row identified by keySet with patchSet, along with the PatchSet to write,
+// which increments the version of a versioned row. The ChangeSet is nil when only fields with the audit option omit change.
 func (p *SpannerPatcher) jsonUpdateSet(
-	ctx context.Context, txn *spanner.ReadWriteTransaction, tableName accesstypes.Resource, keySet resource.KeySet, patchSet *resource.PatchSet, row RowStruct) ([]byte, error,
-) {
-	patchSetColumns, err := p.PatchSetColumns(patchSet, row.Type())
+	ctx context.Context, txn *spanner.ReadWriteTransaction, tableName accesstypes.Resource, keySet resource.KeySet, patchSet *resource.PatchSet,
+	preconditions map[accesstypes.Field]any, row RowStruct,
+) ([]byte, *resource.PatchSet, error) {
+	patchSetColumns, err := p.updateColumns(patchSet, preconditions, row.Type())
 	if err != nil {
-		return nil, errors.Wrap(err, "SpannerPatcher.Columns()")
+		return nil, nil, errors.Wrap(err, "patcher.updateColumns()")
 	}
 
 	where, params, err := p.Where(keySet, row.Type())
 	if err != nil {
-		return nil, errors.Wrap(err, "patcher.Where()")
+		return nil, nil, errors.Wrap(err, "patcher.Where()")
 	}
 
 	stmt := spanner.NewStatement(fmt.Sprintf(`
@@ -372,27 +544,80 @@ func (p *SpannerPatcher) jsonUpdateSet(
 	oldValues := row.New()
 	if err := spxscan.Get(ctx, txn, oldValues, stmt); err != nil {
 		if errors.Is(err, spxscan.ErrNotFound) {
-			return nil, httpio.NewNotFoundMessagef("%s (%s) not found", tableName, keySet.String())
+			return nil, nil, httpio.NewNotFoundMessagef("%s (%s) not found", tableName, keySet.String())
 		}
 
-		return nil, errors.Wrap(err, "spxscan.Get()")
+		return nil, nil, errors.Wrap(err, "spxscan.Get()")
+	}
+
+	if err := checkPreconditions(tableName, keySet, oldValues, preconditions); err != nil {
+		return nil, nil, err
+	}
+
+	versionedPatchSet, versionChange, err := p.incrementVersion(tableName, keySet, oldValues, patchSet, row.Type())
+	if err != nil {
+		return nil, nil, err
 	}
 
 	changeSet, err := p.Diff(oldValues, patchSet)
 	if err != nil {
-		return nil, errors.Wrap(err, "Diff()")
+		return nil, nil, errors.Wrap(err, "Diff()")
 	}
 
 	if len(changeSet) == 0 {
-		return nil, httpio.NewBadRequestMessagef("No changes to apply on %s (%s)", tableName, keySet.String())
+		return nil, nil, httpio.NewBadRequestMessagef("No changes to apply on %s (%s)", tableName, keySet.String())
+	}
+	maps.Copy(changeSet, versionChange)
+
+	if err := auditChangeSet(rowStructType(row), changeSet, p.audit.hashKey); err != nil {
+		return nil, nil, err
+	}
+
+	if len(changeSet) == 0 {
+		// Only fields with the audit option omit changed, so there is nothing to record
+		return nil, versionedPatchSet, nil
 	}
 
 	jsonBytes, err := json.Marshal(changeSet)
 	if err != nil {
-		return nil, errors.Wrap(err, "json.Marshal()")
+		return nil, nil, errors.Wrap(err, "json.Marshal()")
 	}
 
-	return jsonBytes, nil
+	return jsonBytes, versionedPatchSet, nil
+}
+
+// upsertRow reads the columns of the existing row of mutation read by upserts, soft deleted or not, returning nil when it does not exist
+func (p *SpannerPatcher) upsertRow(ctx context.Context, txn *spanner.ReadWriteTransaction, mutation *Mutation) (any, error) {
+	columns, err := p.upsertColumns(mutation.PatchSet, mutation.Preconditions, mutation.RowStruct.Type())
+	if err != nil {
+		return nil, err
+	}
+
+	where, params, err := p.whereAny(mutation.PatchSet.KeySet(), mutation.RowStruct.Type())
+	if err != nil {
+		return nil, errors.Wrap(err, "patcher.whereAny()")
+	}
+
+	stmt := spanner.NewStatement(fmt.Sprintf(`
+			SELECT
+				%s
+			FROM %s
+			WHERE %s`, columns, mutation.TableName, where,
+	))
+	for param, value := range params {
+		stmt.Params[param] = value
+	}
+
+	old := mutation.RowStruct.New()
+	if err := spxscan.Get(ctx, txn, old, stmt); err != nil {
+		if errors.Is(err, spxscan.ErrNotFound) {
+			return nil, nil
+		}
+
+		return nil, errors.Wrap(err, "spxscan.Get()")
+	}
+
+	return old, nil
 }
 
 func (p *SpannerPatcher) jsonDeleteSet(
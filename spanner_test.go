@@ -0,0 +1,224 @@
+package patcher
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/cccteam/ccc/accesstypes"
+	"github.com/cccteam/ccc/resource"
+	"github.com/cccteam/httpio"
+)
+
+func Test_checkDistinctRows(t *testing.T) {
+	t.Parallel()
+
+	type Fruit struct {
+		ID   string `spanner:"Id"`
+		Name string `spanner:"Name"`
+	}
+
+	mutation := func(operation Operation, tableName accesstypes.Resource, id string) *Mutation {
+		patchSet := resource.NewPatchSet().Set("Name", "apple")
+		patchSet.SetKey("ID", id)
+
+		return &Mutation{Operation: operation, TableName: tableName, RowStruct: NewRowStruct(Fruit{}), PatchSet: patchSet}
+	}
+
+	tests := []struct {
+		name      string
+		mutations []*Mutation
+		wantIndex int
+		wantErr   bool
+	}{
+		{
+			name:      "distinct rows",
+			mutations: []*Mutation{mutation(OperationCreate, "Fruits", "1"), mutation(OperationCreate, "Fruits", "2")},
+		},
+		{
+			name:      "same key in other tables",
+			mutations: []*Mutation{mutation(OperationCreate, "Fruits", "1"), mutation(OperationCreate, "Vegetables", "1")},
+		},
+		{
+			name:      "create then update of a row",
+			mutations: []*Mutation{mutation(OperationCreate, "Fruits", "1"), mutation(OperationUpdate, "Fruits", "1")},
+			wantIndex: 1,
+			wantErr:   true,
+		},
+		{
+			name: "update then delete of a row",
+			mutations: []*Mutation{
+				mutation(OperationCreate, "Fruits", "1"), mutation(OperationUpdate, "Fruits", "2"), mutation(OperationDelete, "Fruits", "2"),
+			},
+			wantIndex: 2,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := checkDistinctRows(tt.mutations)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkDistinctRows() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				return
+			}
+
+			var mutationErr *MutationError
+			if !errors.As(err, &mutationErr) {
+				t.Fatalf("checkDistinctRows() error = %T, want *MutationError", err)
+			}
+			if mutationErr.Index != tt.wantIndex || mutationErr.Mutation != tt.mutations[tt.wantIndex] {
+				t.Errorf("MutationError.Index = (%v),  want (%v)", mutationErr.Index, tt.wantIndex)
+			}
+			if !httpio.HasBadRequest(err) {
+				t.Errorf("checkDistinctRows() error = %v, want bad request", err)
+			}
+		})
+	}
+}
+
+func TestSpannerPatcher_BufferApply_duplicateRows(t *testing.T) {
+	t.Parallel()
+
+	type Fruit struct {
+		ID   string `spanner:"Id"`
+		Name string `spanner:"Name"`
+	}
+
+	create := resource.NewPatchSet().Set("Name", "apple")
+	create.SetKey("ID", "1")
+	update := resource.NewPatchSet().Set("Name", "banana")
+	update.SetKey("ID", "1")
+
+	mutations := []*Mutation{
+		{Operation: OperationCreate, TableName: "Fruits", RowStruct: NewRowStruct(Fruit{}), PatchSet: create},
+		{Operation: OperationUpdate, TableName: "Fruits", RowStruct: NewRowStruct(Fruit{}), PatchSet: update},
+	}
+
+	// The duplicate is rejected before anything is buffered, so no transaction is used
+	err := NewSpannerPatcher().BufferApply(context.Background(), nil, "test", mutations...)
+
+	var mutationErr *MutationError
+	if !errors.As(err, &mutationErr) {
+		t.Fatalf("BufferApply() error = %v, want *MutationError", err)
+	}
+	if mutationErr.Index != 1 {
+		t.Errorf("MutationError.Index = (%v),  want (%v)", mutationErr.Index, 1)
+	}
+}
+
+func TestSpannerPatcher_jsonUpsertSet(t *testing.T) {
+	t.Parallel()
+
+	type Fruit struct {
+		ID        string           `spanner:"Id"`
+		Name      string           `spanner:"Name"`
+		Version   int64            `spanner:"Version"   patcher:"version"`
+		DeletedAt spanner.NullTime `spanner:"DeletedAt" patcher:"softdelete"`
+	}
+
+	deletedAt := spanner.NullTime{Time: time.Date(2032, 4, 23, 12, 2, 3, 4, time.UTC), Valid: true}
+
+	tests := []struct {
+		name          string
+		old           any
+		patchSet      *resource.PatchSet
+		wantType      EventType
+		wantChangeSet string
+		wantPatchSet  map[accesstypes.Field]any
+		wantConflict  bool
+	}{
+		{
+			name:          "row does not exist",
+			patchSet:      resource.NewPatchSet().Set("Name", "apple"),
+			wantType:      EventTypeInsert,
+			wantChangeSet: `{"Name":{"Old":"","New":"apple"}}`,
+			wantPatchSet:  map[accesstypes.Field]any{"Name": "apple"},
+		},
+		{
+			name:          "row exists",
+			old:           &Fruit{ID: "1", Name: "apple", Version: 3},
+			patchSet:      resource.NewPatchSet().Set("Name", "banana"),
+			wantType:      EventTypeUpdate,
+			wantChangeSet: `{"Name":{"Old":"apple","New":"banana"},"Version":{"Old":3,"New":4}}`,
+			wantPatchSet:  map[accesstypes.Field]any{"Name": "banana", "Version": int64(4)},
+		},
+		{
+			name:          "version is not checked",
+			old:           &Fruit{ID: "1", Name: "apple", Version: 3},
+			patchSet:      resource.NewPatchSet().Set("Name", "banana").Set("Version", int64(1)),
+			wantType:      EventTypeUpdate,
+			wantChangeSet: `{"Name":{"Old":"apple","New":"banana"},"Version":{"Old":3,"New":4}}`,
+			wantPatchSet:  map[accesstypes.Field]any{"Name": "banana", "Version": int64(4)},
+		},
+		{
+			name:         "no changes",
+			old:          &Fruit{ID: "1", Name: "apple", Version: 3},
+			patchSet:     resource.NewPatchSet().Set("Name", "apple").Set("Version", int64(1)),
+			wantType:     EventTypeUpdate,
+			wantPatchSet: map[accesstypes.Field]any{"Name": "apple"},
+		},
+		{
+			name:         "row is soft deleted",
+			old:          &Fruit{ID: "1", Name: "apple", Version: 3, DeletedAt: deletedAt},
+			patchSet:     resource.NewPatchSet().Set("Name", "banana"),
+			wantConflict: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			tt.patchSet.SetKey("ID", "1")
+			eventType, changeSet, patchSet, err := NewSpannerPatcher().jsonUpsertSet("Fruits", tt.patchSet.KeySet(), tt.old, tt.patchSet, nil, NewRowStruct(Fruit{}))
+			if tt.wantConflict {
+				if !httpio.HasConflict(err) {
+					t.Errorf("SpannerPatcher.jsonUpsertSet() error = %v, want conflict", err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("SpannerPatcher.jsonUpsertSet() error = %v", err)
+			}
+
+			if eventType != tt.wantType {
+				t.Errorf("SpannerPatcher.jsonUpsertSet() EventType = (%v),  want (%v)", eventType, tt.wantType)
+			}
+			if string(changeSet) != tt.wantChangeSet {
+				t.Errorf("SpannerPatcher.jsonUpsertSet() ChangeSet = (%s),  want (%s)", changeSet, tt.wantChangeSet)
+			}
+			if !reflect.DeepEqual(patchSet.Data(), tt.wantPatchSet) {
+				t.Errorf("SpannerPatcher.jsonUpsertSet() PatchSet = (%v),  want (%v)", patchSet.Data(), tt.wantPatchSet)
+			}
+		})
+	}
+}
+
+func TestSpannerPatcher_upsertColumns(t *testing.T) {
+	t.Parallel()
+
+	type Fruit struct {
+		ID        string           `spanner:"Id"`
+		Name      string           `spanner:"Name"`
+		Count     int64            `spanner:"Count"`
+		Version   int64            `spanner:"Version"   patcher:"version"`
+		DeletedAt spanner.NullTime `spanner:"DeletedAt" patcher:"softdelete"`
+	}
+
+	got, err := NewSpannerPatcher().upsertColumns(resource.NewPatchSet().Set("Name", "apple"), map[accesstypes.Field]any{"Count": int64(1)}, &Fruit{})
+	if err != nil {
+		t.Fatalf("SpannerPatcher.upsertColumns() error = %v", err)
+	}
+	if want := "Name, Count, Version, DeletedAt"; got != want {
+		t.Errorf("SpannerPatcher.upsertColumns() = (%v),  want (%v)", got, want)
+	}
+}
@@ -0,0 +1,412 @@
+package patcher
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+
+	"github.com/cccteam/ccc/accesstypes"
+	"github.com/cccteam/ccc/resource"
+	"github.com/cccteam/httpio"
+	"github.com/go-playground/errors/v5"
+)
+
+// errNotFound is returned by sqlTx.get when the query returns no rows
+var errNotFound = errors.New("no rows found")
+
+// sqlTx executes statements within a transaction for the SQL based patchers
+type sqlTx interface {
+	// exec executes query, returning the number of rows affected
+	exec(ctx context.Context, query string, args ...any) (int64, error)
+
+	// get scans the single row returned by query into dst, returning errNotFound when there are no rows
+	get(ctx context.Context, dst any, query string, args ...any) error
+}
+
+// sqlPatcher implements the write path shared by the SQL based patchers. Unlike Spanner,
+// statements are executed immediately, so they are visible to subsequent statements in the transaction.
+type sqlPatcher struct {
+	changeTrackingTable string
+	eventColumns        eventColumns
+	*patcher
+}
+
+// insert inserts the row described by mutation within tx
+func (p *sqlPatcher) insert(ctx context.Context, tx sqlTx, mutation *Mutation) error {
+	patch, err := p.Resolve(mutation.PatchSet, mutation.RowStruct.Type())
+	if err != nil {
+		return errors.Wrap(err, "Resolve()")
+	}
+
+	query, args := p.insertStatement(string(mutation.TableName), patch)
+	if _, err := tx.exec(ctx, query, args...); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p *sqlPatcher) update(ctx context.Context, tx sqlTx, mutation *Mutation) error {
+	patch, err := p.Resolve(mutation.PatchSet, mutation.RowStruct.Type())
+	if err != nil {
+		return errors.Wrap(err, "Resolve()")
+	}
+
+	keySet := mutation.PatchSet.KeySet()
+	query, args, err := p.updateStatement(mutation.TableName, patch, keySet, mutation.RowStruct.Type())
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := tx.exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return p.checkExists(ctx, tx, mutation.TableName, keySet, mutation.RowStruct.Type())
+	}
+
+	return nil
+}
+
+// checkExists returns a not found error unless the row matching keySet exists. An update affecting no rows does not
+// mean the row is missing on MySQL, which reports the rows changed rather than the rows matched, unless the
+// connection is opened with clientFoundRows=true, so an update writing the current values affects no rows.
+func (p *sqlPatcher) checkExists(ctx context.Context, tx sqlTx, tableName accesstypes.Resource, keySet resource.KeySet, databaseType any) error {
+	query, args, err := p.selectForUpdateStatement("1", tableName, keySet, databaseType)
+	if err != nil {
+		return err
+	}
+
+	var exists int64
+	if err := tx.get(ctx, &exists, query, args...); err != nil {
+		if errors.Is(err, errNotFound) {
+			return httpio.NewNotFoundMessagef("%s (%s) not found", tableName, keySet.String())
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (p *sqlPatcher) insertOrUpdate(ctx context.Context, tx sqlTx, mutation *Mutation) error {
+	patch, err := p.Resolve(mutation.PatchSet, mutation.RowStruct.Type())
+	if err != nil {
+		return errors.Wrap(err, "Resolve()")
+	}
+
+	query, args, err := p.upsertStatement(mutation.TableName, patch, mutation.PatchSet.KeySet(), mutation.RowStruct.Type())
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.exec(ctx, query, args...); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p *sqlPatcher) delete(ctx context.Context, tx sqlTx, mutation *Mutation) error {
+	query, args, err := p.deleteStatement(mutation.TableName, mutation.PatchSet.KeySet(), mutation.RowStruct.Type())
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.exec(ctx, query, args...); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p *sqlPatcher) insertWithDataChangeEvent(ctx context.Context, tx sqlTx, eventSource string, mutation *Mutation) error {
+	jsonChangeSet, err := p.jsonInsertSet(mutation.PatchSet, mutation.RowStruct)
+	if err != nil {
+		return err
+	}
+
+	if err := p.insert(ctx, tx, mutation); err != nil {
+		return err
+	}
+
+	if jsonChangeSet == nil {
+		return nil
+	}
+
+	if err := p.insertDataChangeEvent(ctx, tx, &DataChangeEvent{
+		TableName:   mutation.TableName,
+		RowID:       mutation.PatchSet.KeySet().RowID(),
+		EventSource: eventSource,
+		EventType:   EventTypeInsert,
+		ChangeSet:   string(jsonChangeSet),
+	}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// insertOrUpdateWithDataChangeEvent records an insert ChangeSet when the row does not exist, and an update ChangeSet
+// diffed against the existing row when it does. An upsert changing nothing writes the row without recording a data
+// change event. The version of a versioned row is incremented without being checked, and upserting a soft deleted
+// row fails with a conflict.
+func (p *sqlPatcher) insertOrUpdateWithDataChangeEvent(ctx context.Context, tx sqlTx, eventSource string, mutation *Mutation) error {
+	keySet := mutation.PatchSet.KeySet()
+	old, err := p.upsertRow(ctx, tx, mutation)
+	if err != nil {
+		return err
+	}
+
+	eventType, jsonChangeSet, patchSet, err := p.jsonUpsertSet(mutation.TableName, keySet, old, mutation.PatchSet, mutation.Preconditions, mutation.RowStruct)
+	if err != nil {
+		return err
+	}
+
+	if err := p.insertOrUpdate(ctx, tx, mutation.withPatchSet(patchSet)); err != nil {
+		return err
+	}
+
+	if jsonChangeSet == nil {
+		return nil
+	}
+
+	if err := p.insertDataChangeEvent(ctx, tx, &DataChangeEvent{
+		TableName:   mutation.TableName,
+		RowID:       keySet.RowID(),
+		EventSource: eventSource,
+		EventType:   eventType,
+		ChangeSet:   string(jsonChangeSet),
+	}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// updateWithDataChangeEvent updates the row and records its data change event. When the RowStruct has a
+// version field, the version in the PatchSet must match the row, and is incremented by the update.
+func (p *sqlPatcher) updateWithDataChangeEvent(ctx context.Context, tx sqlTx, eventSource string, mutation *Mutation) error {
+	keySet := mutation.PatchSet.KeySet()
+	jsonChangeSet, patchSet, err := p.jsonUpdateSet(ctx, tx, mutation.TableName, keySet, mutation.PatchSet, mutation.Preconditions, mutation.RowStruct)
+	if err != nil {
+		return err
+	}
+
+	if err := p.update(ctx, tx, mutation.withPatchSet(patchSet)); err != nil {
+		return err
+	}
+
+	if jsonChangeSet == nil {
+		return nil
+	}
+
+	if err := p.insertDataChangeEvent(ctx, tx, &DataChangeEvent{
+		TableName:   mutation.TableName,
+		RowID:       keySet.RowID(),
+		EventSource: eventSource,
+		EventType:   EventTypeUpdate,
+		ChangeSet:   string(jsonChangeSet),
+	}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p *sqlPatcher) deleteWithDataChangeEvent(ctx context.Context, tx sqlTx, eventSource string, mutation *Mutation) error {
+	keySet := mutation.PatchSet.KeySet()
+	jsonChangeSet, err := p.jsonDeleteSet(ctx, tx, mutation.TableName, keySet, mutation.RowStruct)
+	if err != nil {
+		return err
+	}
+
+	if err := p.delete(ctx, tx, mutation); err != nil {
+		return err
+	}
+
+	if err := p.insertDataChangeEvent(ctx, tx, &DataChangeEvent{
+		TableName:   mutation.TableName,
+		RowID:       keySet.RowID(),
+		EventSource: eventSource,
+		EventType:   EventTypeDelete,
+		ChangeSet:   string(jsonChangeSet),
+	}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p *sqlPatcher) apply(ctx context.Context, tx sqlTx, eventSource string, mutations ...*Mutation) error {
+	for i, mutation := range mutations {
+		if err := p.mutate(ctx, tx, eventSource, mutation); err != nil {
+			return &MutationError{Index: i, Mutation: mutation, Err: err}
+		}
+	}
+
+	return nil
+}
+
+func (p *sqlPatcher) mutate(ctx context.Context, tx sqlTx, eventSource string, mutation *Mutation) error {
+	switch mutation.Operation {
+	case OperationCreate:
+		return p.insertWithDataChangeEvent(ctx, tx, eventSource, mutation)
+	case OperationUpdate:
+		return p.updateWithDataChangeEvent(ctx, tx, eventSource, mutation)
+	case OperationUpsert:
+		return p.insertOrUpdateWithDataChangeEvent(ctx, tx, eventSource, mutation)
+	case OperationDelete:
+		return p.deleteWithDataChangeEvent(ctx, tx, eventSource, mutation)
+	default:
+		return errors.Newf("unsupported operation %q", mutation.Operation)
+	}
+}
+
+// insertDataChangeEvent writes event to the change tracking table, unless its table is not audited
+func (p *sqlPatcher) insertDataChangeEvent(ctx context.Context, tx sqlTx, event *DataChangeEvent) error {
+	if !p.audit.audited(event.TableName) {
+		return nil
+	}
+
+	event.ChangeSetVersion = ChangeSetVersion
+	event.setFromContext(ctx)
+
+	patch, err := p.dataChangeEventPatch(event, sqlExpr(p.dialect.CommitTimestamp()), p.eventColumns)
+	if err != nil {
+		return err
+	}
+
+	query, args := p.insertStatement(p.changeTrackingTable, patch)
+	if _, err := tx.exec(ctx, query, args...); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// upsertRow reads the columns of the existing row of mutation read by upserts, soft deleted or not, returning nil when it does not exist
+func (p *sqlPatcher) upsertRow(ctx context.Context, tx sqlTx, mutation *Mutation) (any, error) {
+	columns, err := p.upsertColumns(mutation.PatchSet, mutation.Preconditions, mutation.RowStruct.Type())
+	if err != nil {
+		return nil, err
+	}
+
+	query, args, err := p.selectAnyForUpdateStatement(columns, mutation.TableName, mutation.PatchSet.KeySet(), mutation.RowStruct.Type())
+	if err != nil {
+		return nil, err
+	}
+
+	old := mutation.RowStruct.New()
+	if err := tx.get(ctx, old, query, args...); err != nil {
+		if errors.Is(err, errNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return old, nil
+}
+
+// jsonUpdateSet returns the ChangeSet of updating the row identified by keySet with patchSet, along with the PatchSet to write,
+// which increments the version of a versioned row. The ChangeSet is nil when only fields with the audit option omit change.
+func (p *sqlPatcher) jsonUpdateSet(
+	ctx context.Context, tx sqlTx, tableName accesstypes.Resource, keySet resource.KeySet, patchSet *resource.PatchSet,
+	preconditions map[accesstypes.Field]any, row RowStruct,
+) ([]byte, *resource.PatchSet, error) {
+	patchSetColumns, err := p.updateColumns(patchSet, preconditions, row.Type())
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "patcher.updateColumns()")
+	}
+
+	query, args, err := p.selectForUpdateStatement(patchSetColumns, tableName, keySet, row.Type())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	oldValues := row.New()
+	if err := tx.get(ctx, oldValues, query, args...); err != nil {
+		if errors.Is(err, errNotFound) {
+			return nil, nil, httpio.NewNotFoundMessagef("%s (%s) not found", tableName, keySet.String())
+		}
+
+		return nil, nil, err
+	}
+
+	if err := checkPreconditions(tableName, keySet, oldValues, preconditions); err != nil {
+		return nil, nil, err
+	}
+
+	versionedPatchSet, versionChange, err := p.incrementVersion(tableName, keySet, oldValues, patchSet, row.Type())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changeSet, err := p.Diff(oldValues, patchSet)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Diff()")
+	}
+
+	if len(changeSet) == 0 {
+		return nil, nil, httpio.NewBadRequestMessagef("No changes to apply on %s (%s)", tableName, keySet.String())
+	}
+	maps.Copy(changeSet, versionChange)
+
+	if err := auditChangeSet(rowStructType(row), changeSet, p.audit.hashKey); err != nil {
+		return nil, nil, err
+	}
+
+	if len(changeSet) == 0 {
+		// Only fields with the audit option omit changed, so there is nothing to record
+		return nil, versionedPatchSet, nil
+	}
+
+	jsonBytes, err := json.Marshal(changeSet)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "json.Marshal()")
+	}
+
+	return jsonBytes, versionedPatchSet, nil
+}
+
+func (p *sqlPatcher) jsonDeleteSet(
+	ctx context.Context, tx sqlTx, tableName accesstypes.Resource, keySet resource.KeySet, row RowStruct,
+) ([]byte, error) {
+	columns, err := p.AllColumns(row.Type())
+	if err != nil {
+		return nil, errors.Wrap(err, "sqlPatcher.Columns()")
+	}
+
+	query, args, err := p.selectForUpdateStatement(columns, tableName, keySet, row.Type())
+	if err != nil {
+		return nil, err
+	}
+
+	oldValues := row.New()
+	if err := tx.get(ctx, oldValues, query, args...); err != nil {
+		if errors.Is(err, errNotFound) {
+			return nil, httpio.NewNotFoundMessagef("%s (%s) not found", tableName, keySet.RowID())
+		}
+
+		return nil, err
+	}
+
+	changeSet, err := p.deleteChangeSet(oldValues)
+	if err != nil {
+		return nil, errors.Wrap(err, "Diff()")
+	}
+
+	if len(changeSet) == 0 {
+		return nil, httpio.NewBadRequestMessage("No changes to apply")
+	}
+
+	jsonBytes, err := json.Marshal(changeSet)
+	if err != nil {
+		return nil, errors.Wrap(err, "json.Marshal()")
+	}
+
+	return jsonBytes, nil
+}
@@ -0,0 +1,302 @@
+package patcher
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/cccteam/ccc/accesstypes"
+	"github.com/georgysavva/scany/v2/sqlscan"
+	"github.com/go-playground/errors/v5"
+)
+
+// SQLBeginner is implemented by *sql.DB and *sql.Conn
+type SQLBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+var _ Patcher[SQLBeginner] = (*SQLPatcher)(nil)
+
+// SQLPatcher is a patcher for database/sql drivers, building statements with its Dialect.
+// Struct fields are mapped to columns using the db struct tag.
+type SQLPatcher struct {
+	*sqlPatcher
+}
+
+func NewSQLPatcher(dialect Dialect) *SQLPatcher {
+	return &SQLPatcher{
+		sqlPatcher: &sqlPatcher{
+			changeTrackingTable: "DataChangeEvents",
+			patcher:             newPatcher("db", dialect),
+		},
+	}
+}
+
+func (p *SQLPatcher) WithDataChangeTableName(tableName string) *SQLPatcher {
+	p.changeTrackingTable = tableName
+
+	return p
+}
+
+// WithDataChangeEventColumns enables the optional columns of the change tracking table, see DataChangeEventColumn
+func (p *SQLPatcher) WithDataChangeEventColumns(columns ...DataChangeEventColumn) *SQLPatcher {
+	p.eventColumns = columns
+
+	return p
+}
+
+// WithTableAudit enables or disables recording the data change events of tableNames, see SpannerPatcher.WithTableAudit
+func (p *SQLPatcher) WithTableAudit(enabled bool, tableNames ...accesstypes.Resource) *SQLPatcher {
+	p.audit.setTables(enabled, tableNames)
+
+	return p
+}
+
+// WithAuditHashKey sets the key of the audit option hash, see SpannerPatcher.WithAuditHashKey
+func (p *SQLPatcher) WithAuditHashKey(key []byte) *SQLPatcher {
+	p.audit.hashKey = key
+
+	return p
+}
+
+func (p *SQLPatcher) Insert(ctx context.Context, db SQLBeginner, mutation *Mutation) error {
+	if err := beginFunc(ctx, db, func(tx *sql.Tx) error {
+		if err := p.BufferInsert(ctx, tx, mutation); err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "beginFunc()")
+	}
+
+	return nil
+}
+
+func (p *SQLPatcher) Update(ctx context.Context, db SQLBeginner, mutation *Mutation) error {
+	if err := beginFunc(ctx, db, func(tx *sql.Tx) error {
+		if err := p.BufferUpdate(ctx, tx, mutation); err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "beginFunc()")
+	}
+
+	return nil
+}
+
+func (p *SQLPatcher) InsertOrUpdate(ctx context.Context, db SQLBeginner, mutation *Mutation) error {
+	if err := beginFunc(ctx, db, func(tx *sql.Tx) error {
+		if err := p.BufferInsertOrUpdate(ctx, tx, mutation); err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "beginFunc()")
+	}
+
+	return nil
+}
+
+func (p *SQLPatcher) Delete(ctx context.Context, db SQLBeginner, mutation *Mutation) error {
+	if err := beginFunc(ctx, db, func(tx *sql.Tx) error {
+		if err := p.BufferDelete(ctx, tx, mutation); err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "beginFunc()")
+	}
+
+	return nil
+}
+
+func (p *SQLPatcher) InsertWithDataChangeEvent(ctx context.Context, db SQLBeginner, eventSource string, mutation *Mutation) error {
+	if err := beginFunc(ctx, db, func(tx *sql.Tx) error {
+		if err := p.BufferInsertWithDataChangeEvent(ctx, tx, eventSource, mutation); err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "beginFunc()")
+	}
+
+	return nil
+}
+
+func (p *SQLPatcher) InsertOrUpdateWithDataChangeEvent(ctx context.Context, db SQLBeginner, eventSource string, mutation *Mutation) error {
+	if err := beginFunc(ctx, db, func(tx *sql.Tx) error {
+		if err := p.BufferInsertOrUpdateWithDataChangeEvent(ctx, tx, eventSource, mutation); err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "beginFunc()")
+	}
+
+	return nil
+}
+
+func (p *SQLPatcher) UpdateWithDataChangeEvent(ctx context.Context, db SQLBeginner, eventSource string, mutation *Mutation) error {
+	if err := beginFunc(ctx, db, func(tx *sql.Tx) error {
+		if err := p.BufferUpdateWithDataChangeEvent(ctx, tx, eventSource, mutation); err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "beginFunc()")
+	}
+
+	return nil
+}
+
+func (p *SQLPatcher) DeleteWithDataChangeEvent(ctx context.Context, db SQLBeginner, eventSource string, mutation *Mutation) error {
+	if err := beginFunc(ctx, db, func(tx *sql.Tx) error {
+		if err := p.BufferDeleteWithDataChangeEvent(ctx, tx, eventSource, mutation); err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "beginFunc()")
+	}
+
+	return nil
+}
+
+// Mutate applies mutation, along with its data change event, dispatching on its Operation
+func (p *SQLPatcher) Mutate(ctx context.Context, db SQLBeginner, eventSource string, mutation *Mutation) error {
+	if err := beginFunc(ctx, db, func(tx *sql.Tx) error {
+		if err := p.BufferMutate(ctx, tx, eventSource, mutation); err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "beginFunc()")
+	}
+
+	return nil
+}
+
+// Apply applies mutations, along with their data change events, in a single transaction.
+func (p *SQLPatcher) Apply(ctx context.Context, db SQLBeginner, eventSource string, mutations ...*Mutation) error {
+	if err := beginFunc(ctx, db, func(tx *sql.Tx) error {
+		if err := p.BufferApply(ctx, tx, eventSource, mutations...); err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "beginFunc()")
+	}
+
+	return nil
+}
+
+// BufferApply applies mutations, along with their data change events, within tx, dispatching each on its Operation.
+// A failure is returned as a *MutationError identifying the mutation.
+func (p *SQLPatcher) BufferApply(ctx context.Context, tx *sql.Tx, eventSource string, mutations ...*Mutation) error {
+	return p.apply(ctx, &stdTx{tx: tx}, eventSource, mutations...)
+}
+
+// BufferMutate applies mutation, along with its data change event, within tx, dispatching on its Operation
+func (p *SQLPatcher) BufferMutate(ctx context.Context, tx *sql.Tx, eventSource string, mutation *Mutation) error {
+	return p.mutate(ctx, &stdTx{tx: tx}, eventSource, mutation)
+}
+
+// BufferInsert inserts the row described by mutation within tx. The statement is executed
+// immediately, so it is visible to subsequent statements in tx.
+func (p *SQLPatcher) BufferInsert(ctx context.Context, tx *sql.Tx, mutation *Mutation) error {
+	return p.insert(ctx, &stdTx{tx: tx}, mutation)
+}
+
+func (p *SQLPatcher) BufferUpdate(ctx context.Context, tx *sql.Tx, mutation *Mutation) error {
+	return p.update(ctx, &stdTx{tx: tx}, mutation)
+}
+
+func (p *SQLPatcher) BufferInsertOrUpdate(ctx context.Context, tx *sql.Tx, mutation *Mutation) error {
+	return p.insertOrUpdate(ctx, &stdTx{tx: tx}, mutation)
+}
+
+func (p *SQLPatcher) BufferDelete(ctx context.Context, tx *sql.Tx, mutation *Mutation) error {
+	return p.delete(ctx, &stdTx{tx: tx}, mutation)
+}
+
+func (p *SQLPatcher) BufferInsertWithDataChangeEvent(ctx context.Context, tx *sql.Tx, eventSource string, mutation *Mutation) error {
+	return p.insertWithDataChangeEvent(ctx, &stdTx{tx: tx}, eventSource, mutation)
+}
+
+// BufferInsertOrUpdateWithDataChangeEvent records an insert ChangeSet when the row does not exist, and an update ChangeSet
+// diffed against the existing row when it does. An upsert changing nothing writes the row without recording a data change event.
+// The version of a versioned row is incremented without being checked, and upserting a soft deleted row fails with a conflict.
+func (p *SQLPatcher) BufferInsertOrUpdateWithDataChangeEvent(ctx context.Context, tx *sql.Tx, eventSource string, mutation *Mutation) error {
+	return p.insertOrUpdateWithDataChangeEvent(ctx, &stdTx{tx: tx}, eventSource, mutation)
+}
+
+func (p *SQLPatcher) BufferUpdateWithDataChangeEvent(ctx context.Context, tx *sql.Tx, eventSource string, mutation *Mutation) error {
+	return p.updateWithDataChangeEvent(ctx, &stdTx{tx: tx}, eventSource, mutation)
+}
+
+func (p *SQLPatcher) BufferDeleteWithDataChangeEvent(ctx context.Context, tx *sql.Tx, eventSource string, mutation *Mutation) error {
+	return p.deleteWithDataChangeEvent(ctx, &stdTx{tx: tx}, eventSource, mutation)
+}
+
+// beginFunc runs fn in a transaction, committing when fn succeeds and rolling back otherwise
+func beginFunc(ctx context.Context, db SQLBeginner, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "SQLBeginner.BeginTx()")
+	}
+
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return errors.Wrapf(err, "sql.Tx.Rollback() failed: %s", rbErr)
+		}
+
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "sql.Tx.Commit()")
+	}
+
+	return nil
+}
+
+// stdTx implements sqlTx for *sql.Tx
+type stdTx struct {
+	tx *sql.Tx
+}
+
+func (t *stdTx) exec(ctx context.Context, query string, args ...any) (int64, error) {
+	result, err := t.tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, "sql.Tx.ExecContext()")
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "sql.Result.RowsAffected()")
+	}
+
+	return rowsAffected, nil
+}
+
+func (t *stdTx) get(ctx context.Context, dst any, query string, args ...any) error {
+	if err := sqlscan.Get(ctx, t.tx, dst, query, args...); err != nil {
+		if sqlscan.NotFound(err) {
+			return errNotFound
+		}
+
+		return errors.Wrap(err, "sqlscan.Get()")
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package patcher
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cccteam/ccc/accesstypes"
+)
+
+// SQLiteDialect is the Dialect for SQLite
+type SQLiteDialect struct{}
+
+func (SQLiteDialect) Name() string {
+	return "sqlite"
+}
+
+func (SQLiteDialect) QuoteIdentifier(identifier string) string {
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
+
+// Placeholder returns a numbered parameter (?NNN), so a statement may be bound in order of appearance
+func (SQLiteDialect) Placeholder(_ string, position int) string {
+	return fmt.Sprintf("?%d", position)
+}
+
+// BindArgs returns the values of params in order
+func (SQLiteDialect) BindArgs(params []Param) []any {
+	args := make([]any, 0, len(params))
+	for _, param := range params {
+		args = append(args, param.Value)
+	}
+
+	return args
+}
+
+func (SQLiteDialect) Upsert(table string, columns, values, keyColumns []string) string {
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) %s",
+		table, strings.Join(columns, ", "), strings.Join(values, ", "), strings.Join(keyColumns, ", "), onConflict(columns, keyColumns),
+	)
+}
+
+// ForUpdate returns an empty clause, SQLite locks the whole database when a transaction writes
+func (SQLiteDialect) ForUpdate() string {
+	return ""
+}
+
+// CommitTimestamp returns the current UTC time with millisecond precision
+func (SQLiteDialect) CommitTimestamp() string {
+	return "strftime('%Y-%m-%d %H:%M:%f', 'now')"
+}
+
+// SQLitePatcher is a SQLPatcher using the SQLiteDialect
+type SQLitePatcher struct {
+	*SQLPatcher
+}
+
+func NewSQLitePatcher() *SQLitePatcher {
+	return &SQLitePatcher{
+		SQLPatcher: NewSQLPatcher(SQLiteDialect{}),
+	}
+}
+
+func (p *SQLitePatcher) WithDataChangeTableName(tableName string) *SQLitePatcher {
+	p.changeTrackingTable = tableName
+
+	return p
+}
+
+// WithDataChangeEventColumns enables the optional columns of the change tracking table, see DataChangeEventColumn
+func (p *SQLitePatcher) WithDataChangeEventColumns(columns ...DataChangeEventColumn) *SQLitePatcher {
+	p.eventColumns = columns
+
+	return p
+}
+
+// WithTableAudit enables or disables recording the data change events of tableNames, see SpannerPatcher.WithTableAudit
+func (p *SQLitePatcher) WithTableAudit(enabled bool, tableNames ...accesstypes.Resource) *SQLitePatcher {
+	p.audit.setTables(enabled, tableNames)
+
+	return p
+}
+
+// WithAuditHashKey sets the key of the audit option hash, see SpannerPatcher.WithAuditHashKey
+func (p *SQLitePatcher) WithAuditHashKey(key []byte) *SQLitePatcher {
+	p.audit.hashKey = key
+
+	return p
+}
@@ -0,0 +1,751 @@
+package patcher
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cccteam/ccc/accesstypes"
+	"github.com/cccteam/ccc/resource"
+	"github.com/cccteam/httpio"
+	"github.com/georgysavva/scany/v2/sqlscan"
+	"github.com/go-playground/errors/v5"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteDataChangeEvents creates the change tracking table with the EventType and ChangeSetVersion columns
+const sqliteDataChangeEvents = `CREATE TABLE DataChangeEvents (TableName TEXT, RowId TEXT, EventTime TEXT, EventSource TEXT, EventType TEXT, ChangeSet TEXT, ChangeSetVersion INTEGER)`
+
+// newSQLiteDB opens a new SQLite database for the test, executing the statements of schema, and closes it when the test ends
+func newSQLiteDB(t *testing.T, schema ...string) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "patcher.db"))
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	for _, statement := range schema {
+		if _, err := db.ExecContext(context.Background(), statement); err != nil {
+			t.Fatalf("sql.DB.ExecContext() error = %v", err)
+		}
+	}
+
+	return db
+}
+
+func TestSQLitePatcher_WithDataChangeEvent(t *testing.T) {
+	t.Parallel()
+
+	type Fruit struct {
+		ID    string `db:"Id"`
+		Name  string `db:"Name"`
+		Count int64  `db:"Count"`
+	}
+
+	ctx := context.Background()
+	db := newSQLiteDB(t,
+		`CREATE TABLE Fruits (Id TEXT PRIMARY KEY, Name TEXT NOT NULL, Count INTEGER NOT NULL)`,
+		sqliteDataChangeEvents,
+	)
+
+	p := NewSQLitePatcher().WithDataChangeEventColumns(EventTypeColumn, ChangeSetVersionColumn)
+	mutation := func(patchSet *resource.PatchSet) *Mutation {
+		patchSet.SetKey("ID", "1")
+
+		return &Mutation{TableName: "Fruits", RowStruct: NewRowStruct(Fruit{}), PatchSet: patchSet}
+	}
+
+	if err := p.InsertWithDataChangeEvent(ctx, db, "test", mutation(resource.NewPatchSet().Set("Name", "apple").Set("Count", int64(1)))); err != nil {
+		t.Fatalf("SQLitePatcher.InsertWithDataChangeEvent() error = %v", err)
+	}
+	if err := p.UpdateWithDataChangeEvent(ctx, db, "test", mutation(resource.NewPatchSet().Set("Count", int64(2)))); err != nil {
+		t.Fatalf("SQLitePatcher.UpdateWithDataChangeEvent() error = %v", err)
+	}
+	if err := p.InsertOrUpdateWithDataChangeEvent(ctx, db, "test", mutation(resource.NewPatchSet().Set("Name", "banana").Set("Count", int64(2)))); err != nil {
+		t.Fatalf("SQLitePatcher.InsertOrUpdateWithDataChangeEvent() error = %v", err)
+	}
+	if err := p.UpdateWithDataChangeEvent(ctx, db, "test", mutation(resource.NewPatchSet().Set("Count", int64(2)))); !httpio.HasBadRequest(err) {
+		t.Fatalf("SQLitePatcher.UpdateWithDataChangeEvent() error = %v, want bad request", err)
+	}
+	if err := p.DeleteWithDataChangeEvent(ctx, db, "test", mutation(resource.NewPatchSet())); err != nil {
+		t.Fatalf("SQLitePatcher.DeleteWithDataChangeEvent() error = %v", err)
+	}
+	if err := p.UpdateWithDataChangeEvent(ctx, db, "test", mutation(resource.NewPatchSet().Set("Count", int64(3)))); !httpio.HasNotFound(err) {
+		t.Fatalf("SQLitePatcher.UpdateWithDataChangeEvent() error = %v, want not found", err)
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT RowId, EventType, ChangeSet FROM DataChangeEvents ORDER BY rowid`)
+	if err != nil {
+		t.Fatalf("sql.DB.QueryContext() error = %v", err)
+	}
+	defer rows.Close()
+
+	var got []string
+	var gotTypes []EventType
+	for rows.Next() {
+		var rowID, changeSet string
+		var eventType EventType
+		if err := rows.Scan(&rowID, &eventType, &changeSet); err != nil {
+			t.Fatalf("sql.Rows.Scan() error = %v", err)
+		}
+		if rowID != "1" {
+			t.Errorf("RowId = (%v),  want (%v)", rowID, "1")
+		}
+		got = append(got, changeSet)
+		gotTypes = append(gotTypes, eventType)
+	}
+
+	want := []string{
+		`{"Count":{"Old":0,"New":1},"Name":{"Old":"","New":"apple"}}`,
+		`{"Count":{"Old":1,"New":2}}`,
+		`{"Name":{"Old":"apple","New":"banana"}}`,
+		`{"Count":{"Old":2,"New":null},"ID":{"Old":"1","New":null},"Name":{"Old":"banana","New":null}}`,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ChangeSets = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("ChangeSets[%d] = (%v),  want (%v)", i, got[i], want[i])
+		}
+	}
+
+	wantTypes := []EventType{EventTypeInsert, EventTypeUpdate, EventTypeUpdate, EventTypeDelete}
+	if !slices.Equal(gotTypes, wantTypes) {
+		t.Errorf("EventTypes = (%v),  want (%v)", gotTypes, wantTypes)
+	}
+}
+
+func TestSQLitePatcher_Apply(t *testing.T) {
+	t.Parallel()
+
+	type Fruit struct {
+		ID   string `db:"Id"`
+		Name string `db:"Name"`
+	}
+
+	ctx := context.Background()
+	db := newSQLiteDB(t,
+		`CREATE TABLE Fruits (Id TEXT PRIMARY KEY, Name TEXT NOT NULL)`,
+		sqliteDataChangeEvents,
+	)
+
+	mutation := func(operation Operation, id, name string) *Mutation {
+		patchSet := resource.NewPatchSet()
+		if name != "" {
+			patchSet.Set("Name", name)
+		}
+		patchSet.SetKey("ID", id)
+
+		return &Mutation{Operation: operation, TableName: "Fruits", RowStruct: NewRowStruct(Fruit{}), PatchSet: patchSet}
+	}
+
+	p := NewSQLitePatcher()
+	err := p.Apply(ctx, db, "test",
+		mutation(OperationCreate, "1", "apple"),
+		mutation(OperationUpdate, "2", "banana"),
+	)
+
+	var mutationErr *MutationError
+	if !errors.As(err, &mutationErr) || mutationErr.Index != 1 {
+		t.Fatalf("SQLitePatcher.Apply() error = %v, want *MutationError for mutation 1", err)
+	}
+	if !httpio.HasNotFound(err) {
+		t.Errorf("SQLitePatcher.Apply() error = %v, want not found", err)
+	}
+
+	var count int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM Fruits`).Scan(&count); err != nil {
+		t.Fatalf("sql.Row.Scan() error = %v", err)
+	}
+	if count != 0 {
+		t.Errorf("Fruits count = (%v),  want (%v)", count, 0)
+	}
+
+	if err := p.Apply(ctx, db, "test",
+		mutation(OperationCreate, "1", "apple"),
+		mutation(OperationUpsert, "2", "banana"),
+		mutation(OperationUpdate, "1", "cherry"),
+		mutation(OperationDelete, "2", ""),
+	); err != nil {
+		t.Fatalf("SQLitePatcher.Apply() error = %v", err)
+	}
+
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM DataChangeEvents`).Scan(&count); err != nil {
+		t.Fatalf("sql.Row.Scan() error = %v", err)
+	}
+	if count != 4 {
+		t.Errorf("DataChangeEvents count = (%v),  want (%v)", count, 4)
+	}
+}
+
+func TestSQLitePatcher_UpdateWithDataChangeEvent_version(t *testing.T) {
+	t.Parallel()
+
+	type Fruit struct {
+		ID      string `db:"Id"`
+		Name    string `db:"Name"`
+		Version int64  `db:"Version,version"`
+	}
+
+	ctx := context.Background()
+	db := newSQLiteDB(t,
+		`CREATE TABLE Fruits (Id TEXT PRIMARY KEY, Name TEXT NOT NULL, Version INTEGER NOT NULL)`,
+		sqliteDataChangeEvents,
+		`INSERT INTO Fruits (Id, Name, Version) VALUES ('1', 'apple', 1)`,
+	)
+
+	p := NewSQLitePatcher()
+	mutation := func(patchSet *resource.PatchSet) *Mutation {
+		patchSet.SetKey("ID", "1")
+
+		return &Mutation{TableName: "Fruits", RowStruct: NewRowStruct(Fruit{}), PatchSet: patchSet}
+	}
+
+	if err := p.UpdateWithDataChangeEvent(ctx, db, "test", mutation(resource.NewPatchSet().Set("Name", "banana"))); !httpio.HasBadRequest(err) {
+		t.Fatalf("SQLitePatcher.UpdateWithDataChangeEvent() error = %v, want bad request", err)
+	}
+	if err := p.UpdateWithDataChangeEvent(ctx, db, "test", mutation(resource.NewPatchSet().Set("Name", "banana").Set("Version", int64(1)))); err != nil {
+		t.Fatalf("SQLitePatcher.UpdateWithDataChangeEvent() error = %v", err)
+	}
+	if err := p.UpdateWithDataChangeEvent(ctx, db, "test", mutation(resource.NewPatchSet().Set("Name", "cherry").Set("Version", int64(1)))); !httpio.HasConflict(err) {
+		t.Fatalf("SQLitePatcher.UpdateWithDataChangeEvent() error = %v, want conflict", err)
+	}
+
+	var name string
+	var version int64
+	if err := db.QueryRowContext(ctx, `SELECT Name, Version FROM Fruits WHERE Id = '1'`).Scan(&name, &version); err != nil {
+		t.Fatalf("sql.Row.Scan() error = %v", err)
+	}
+	if name != "banana" || version != 2 {
+		t.Errorf("Fruit = (%v, %v),  want (%v, %v)", name, version, "banana", 2)
+	}
+
+	var changeSet string
+	if err := db.QueryRowContext(ctx, `SELECT ChangeSet FROM DataChangeEvents`).Scan(&changeSet); err != nil {
+		t.Fatalf("sql.Row.Scan() error = %v", err)
+	}
+	if want := `{"Name":{"Old":"apple","New":"banana"},"Version":{"Old":1,"New":2}}`; changeSet != want {
+		t.Errorf("ChangeSet = (%v),  want (%v)", changeSet, want)
+	}
+}
+
+func TestSQLitePatcher_UpdateWithDataChangeEvent_preconditions(t *testing.T) {
+	t.Parallel()
+
+	type Fruit struct {
+		ID    string `db:"Id"`
+		Name  string `db:"Name"`
+		Count int64  `db:"Count"`
+	}
+
+	ctx := context.Background()
+	db := newSQLiteDB(t,
+		`CREATE TABLE Fruits (Id TEXT PRIMARY KEY, Name TEXT NOT NULL, Count INTEGER NOT NULL)`,
+		sqliteDataChangeEvents,
+		`INSERT INTO Fruits (Id, Name, Count) VALUES ('1', 'apple', 1)`,
+	)
+
+	p := NewSQLitePatcher()
+	mutation := func(preconditions map[accesstypes.Field]any) *Mutation {
+		patchSet := resource.NewPatchSet().Set("Count", int64(5))
+		patchSet.SetKey("ID", "1")
+
+		return &Mutation{TableName: "Fruits", RowStruct: NewRowStruct(Fruit{}), PatchSet: patchSet, Preconditions: preconditions}
+	}
+
+	err := p.UpdateWithDataChangeEvent(ctx, db, "test", mutation(map[accesstypes.Field]any{"Name": "banana", "Count": int64(1)}))
+	if !httpio.HasConflict(err) {
+		t.Fatalf("SQLitePatcher.UpdateWithDataChangeEvent() error = %v, want conflict", err)
+	}
+
+	var preconditionErr *PreconditionError
+	if !errors.As(err, &preconditionErr) {
+		t.Fatalf("SQLitePatcher.UpdateWithDataChangeEvent() error = %v, want *PreconditionError", err)
+	}
+	want := []PreconditionMismatch{{Field: "Name", Expected: "banana", Actual: "apple"}}
+	if !reflect.DeepEqual(preconditionErr.Mismatches, want) {
+		t.Errorf("PreconditionError.Mismatches = (%v),  want (%v)", preconditionErr.Mismatches, want)
+	}
+
+	if err := p.UpdateWithDataChangeEvent(ctx, db, "test", mutation(map[accesstypes.Field]any{"Name": "apple", "Count": int64(1)})); err != nil {
+		t.Fatalf("SQLitePatcher.UpdateWithDataChangeEvent() error = %v", err)
+	}
+}
+
+func TestSQLitePatcher_DeleteWithDataChangeEvent_softDelete(t *testing.T) {
+	t.Parallel()
+
+	type Fruit struct {
+		ID        string     `db:"Id"`
+		Name      string     `db:"Name"`
+		DeletedAt *time.Time `db:"DeletedAt,softdelete"`
+	}
+
+	ctx := context.Background()
+	db := newSQLiteDB(t,
+		`CREATE TABLE Fruits (Id TEXT PRIMARY KEY, Name TEXT NOT NULL, DeletedAt TEXT)`,
+		sqliteDataChangeEvents,
+		`INSERT INTO Fruits (Id, Name) VALUES ('1', 'apple')`,
+	)
+
+	p := NewSQLitePatcher()
+	patchSet := resource.NewPatchSet()
+	patchSet.SetKey("ID", "1")
+	mutation := &Mutation{TableName: "Fruits", RowStruct: NewRowStruct(Fruit{}), PatchSet: patchSet}
+
+	if err := p.DeleteWithDataChangeEvent(ctx, db, "test", mutation); err != nil {
+		t.Fatalf("SQLitePatcher.DeleteWithDataChangeEvent() error = %v", err)
+	}
+	if err := p.DeleteWithDataChangeEvent(ctx, db, "test", mutation); !httpio.HasNotFound(err) {
+		t.Fatalf("SQLitePatcher.DeleteWithDataChangeEvent() error = %v, want not found", err)
+	}
+
+	var deletedAt sql.NullString
+	if err := db.QueryRowContext(ctx, `SELECT DeletedAt FROM Fruits WHERE Id = '1'`).Scan(&deletedAt); err != nil {
+		t.Fatalf("sql.Row.Scan() error = %v", err)
+	}
+	if !deletedAt.Valid {
+		t.Errorf("DeletedAt = NULL, want the time of the delete")
+	}
+
+	var changeSet string
+	if err := db.QueryRowContext(ctx, `SELECT ChangeSet FROM DataChangeEvents`).Scan(&changeSet); err != nil {
+		t.Fatalf("sql.Row.Scan() error = %v", err)
+	}
+	if want := `{"ID":{"Old":"1","New":null},"Name":{"Old":"apple","New":null}}`; changeSet != want {
+		t.Errorf("ChangeSet = (%v),  want (%v)", changeSet, want)
+	}
+}
+
+func TestSQLitePatcher_InsertWithDataChangeEvent_context(t *testing.T) {
+	t.Parallel()
+
+	type Fruit struct {
+		ID   string `db:"Id"`
+		Name string `db:"Name"`
+	}
+
+	ctx := context.Background()
+	db := newSQLiteDB(t,
+		`CREATE TABLE Fruits (Id TEXT PRIMARY KEY, Name TEXT NOT NULL)`,
+		`CREATE TABLE DataChangeEvents (TableName TEXT, RowId TEXT, EventTime TEXT, EventSource TEXT, EventType TEXT, ChangeSet TEXT, ChangeSetVersion INTEGER, CorrelationId TEXT, Reason TEXT)`,
+	)
+
+	p := NewSQLitePatcher().WithDataChangeEventColumns(CorrelationIDColumn, ReasonColumn)
+	mutation := func(id string) *Mutation {
+		patchSet := resource.NewPatchSet().Set("Name", "apple")
+		patchSet.SetKey("ID", id)
+
+		return &Mutation{TableName: "Fruits", RowStruct: NewRowStruct(Fruit{}), PatchSet: patchSet}
+	}
+
+	if err := p.InsertWithDataChangeEvent(WithChangeReason(WithCorrelationID(ctx, "trace-1"), "import"), db, "test", mutation("1")); err != nil {
+		t.Fatalf("SQLitePatcher.InsertWithDataChangeEvent() error = %v", err)
+	}
+	if err := p.InsertWithDataChangeEvent(ctx, db, "test", mutation("2")); err != nil {
+		t.Fatalf("SQLitePatcher.InsertWithDataChangeEvent() error = %v", err)
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT CorrelationId, Reason FROM DataChangeEvents ORDER BY RowId`)
+	if err != nil {
+		t.Fatalf("sql.DB.QueryContext() error = %v", err)
+	}
+	defer rows.Close()
+
+	var got []sql.NullString
+	for rows.Next() {
+		var correlationID, reason sql.NullString
+		if err := rows.Scan(&correlationID, &reason); err != nil {
+			t.Fatalf("sql.Rows.Scan() error = %v", err)
+		}
+		got = append(got, correlationID, reason)
+	}
+
+	want := []sql.NullString{{String: "trace-1", Valid: true}, {String: "import", Valid: true}, {}, {}}
+	if !slices.Equal(got, want) {
+		t.Errorf("CorrelationId, Reason = (%v),  want (%v)", got, want)
+	}
+}
+
+func TestSQLitePatcher_InsertWithDataChangeEvent_contextBaselineTable(t *testing.T) {
+	t.Parallel()
+
+	type Fruit struct {
+		ID   string `db:"Id"`
+		Name string `db:"Name"`
+	}
+
+	ctx := context.Background()
+	db := newSQLiteDB(t,
+		`CREATE TABLE Fruits (Id TEXT PRIMARY KEY, Name TEXT NOT NULL)`,
+		`CREATE TABLE DataChangeEvents (TableName TEXT, RowId TEXT, EventTime TEXT, EventSource TEXT, ChangeSet TEXT)`,
+	)
+
+	patchSet := resource.NewPatchSet().Set("Name", "apple")
+	patchSet.SetKey("ID", "1")
+	mutation := &Mutation{TableName: "Fruits", RowStruct: NewRowStruct(Fruit{}), PatchSet: patchSet}
+
+	// The correlation ID and reason are not recorded, since their columns are not enabled
+	if err := NewSQLitePatcher().InsertWithDataChangeEvent(WithChangeReason(WithCorrelationID(ctx, "trace-1"), "import"), db, "test", mutation); err != nil {
+		t.Fatalf("SQLitePatcher.InsertWithDataChangeEvent() error = %v", err)
+	}
+}
+
+func TestSQLitePatcher_WithDataChangeEvent_audit(t *testing.T) {
+	t.Parallel()
+
+	type Account struct {
+		ID       string `db:"Id"`
+		Name     string `db:"Name"`
+		Password string `db:"Password" audit:"redact"`
+		SSN      string `db:"Ssn"      audit:"hash"`
+		Token    string `db:"Token"    audit:"omit"`
+	}
+
+	ctx := context.Background()
+	db := newSQLiteDB(t,
+		`CREATE TABLE Accounts (Id TEXT PRIMARY KEY, Name TEXT NOT NULL, Password TEXT NOT NULL, Ssn TEXT NOT NULL, Token TEXT NOT NULL)`,
+		sqliteDataChangeEvents,
+	)
+
+	p := NewSQLitePatcher().WithAuditHashKey([]byte("audit key"))
+	mutation := func(patchSet *resource.PatchSet) *Mutation {
+		patchSet.SetKey("ID", "1")
+
+		return &Mutation{TableName: "Accounts", RowStruct: NewRowStruct(Account{}), PatchSet: patchSet}
+	}
+
+	if err := p.InsertWithDataChangeEvent(ctx, db, "test", mutation(resource.NewPatchSet().
+		Set("Name", "ann").Set("Password", "secret").Set("SSN", "123-45-6789").Set("Token", "abc"))); err != nil {
+		t.Fatalf("SQLitePatcher.InsertWithDataChangeEvent() error = %v", err)
+	}
+
+	// A change of an omitted field is written, but records no data change event since there is nothing to record
+	if err := p.UpdateWithDataChangeEvent(ctx, db, "test", mutation(resource.NewPatchSet().Set("Token", "def"))); err != nil {
+		t.Fatalf("SQLitePatcher.UpdateWithDataChangeEvent() error = %v", err)
+	}
+	if err := p.InsertOrUpdateWithDataChangeEvent(ctx, db, "test", mutation(resource.NewPatchSet().
+		Set("Name", "ann").Set("Password", "secret").Set("SSN", "123-45-6789").Set("Token", "ghi"))); err != nil {
+		t.Fatalf("SQLitePatcher.InsertOrUpdateWithDataChangeEvent() error = %v", err)
+	}
+
+	var password, token string
+	if err := db.QueryRowContext(ctx, `SELECT Password, Token FROM Accounts WHERE Id = '1'`).Scan(&password, &token); err != nil {
+		t.Fatalf("sql.Row.Scan() error = %v", err)
+	}
+	if password != "secret" || token != "ghi" {
+		t.Errorf("Password, Token = (%v, %v),  want (secret, ghi)", password, token)
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT ChangeSet FROM DataChangeEvents ORDER BY EventType`)
+	if err != nil {
+		t.Fatalf("sql.DB.QueryContext() error = %v", err)
+	}
+	defer rows.Close()
+
+	var got []string
+	for rows.Next() {
+		var changeSet string
+		if err := rows.Scan(&changeSet); err != nil {
+			t.Fatalf("sql.Rows.Scan() error = %v", err)
+		}
+		got = append(got, changeSet)
+	}
+
+	want := []string{
+		`{"Name":{"Old":"","New":"ann"},"Password":{"Old":"","New":"[REDACTED]"},` +
+			`"SSN":{"Old":"","New":"hmac-sha256:7acacc8c8f0cb54a0b6a8ca8979a51c1673cc1b959aaecc6c890f6e14eb12fb6"}}`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("ChangeSet = (%v),  want (%v)", got, want)
+	}
+}
+
+func TestSQLitePatcher_WithTableAudit(t *testing.T) {
+	t.Parallel()
+
+	type Fruit struct {
+		ID   string `db:"Id"`
+		Name string `db:"Name"`
+	}
+
+	tests := []struct {
+		name    string
+		patcher *SQLitePatcher
+		want    []accesstypes.Resource
+	}{
+		{name: "all tables", patcher: NewSQLitePatcher(), want: []accesstypes.Resource{"Fruits", "Vegetables"}},
+		{name: "opt out", patcher: NewSQLitePatcher().WithTableAudit(false, "Vegetables"), want: []accesstypes.Resource{"Fruits"}},
+		{name: "opt in", patcher: NewSQLitePatcher().WithTableAudit(false).WithTableAudit(true, "Vegetables"), want: []accesstypes.Resource{"Vegetables"}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			db := newSQLiteDB(t,
+				`CREATE TABLE Fruits (Id TEXT PRIMARY KEY, Name TEXT NOT NULL)`,
+				`CREATE TABLE Vegetables (Id TEXT PRIMARY KEY, Name TEXT NOT NULL)`,
+				`CREATE TABLE DataChangeEvents (TableName TEXT, RowId TEXT, EventTime TEXT, EventSource TEXT, ChangeSet TEXT)`,
+			)
+
+			for _, tableName := range []accesstypes.Resource{"Fruits", "Vegetables"} {
+				patchSet := resource.NewPatchSet().Set("Name", "a")
+				patchSet.SetKey("ID", "1")
+				mutation := &Mutation{TableName: tableName, RowStruct: NewRowStruct(Fruit{}), PatchSet: patchSet}
+				if err := tt.patcher.InsertWithDataChangeEvent(ctx, db, "test", mutation); err != nil {
+					t.Fatalf("SQLitePatcher.InsertWithDataChangeEvent() error = %v", err)
+				}
+
+				// The row is written whether or not its table is audited
+				var count int
+				if err := db.QueryRowContext(ctx, fmt.Sprintf(`SELECT COUNT(*) FROM %s`, tableName)).Scan(&count); err != nil {
+					t.Fatalf("sql.Row.Scan() error = %v", err)
+				}
+				if count != 1 {
+					t.Errorf("rows of %s = (%d),  want (1)", tableName, count)
+				}
+			}
+
+			var got []accesstypes.Resource
+			if err := sqlscan.Select(ctx, db, &got, `SELECT TableName FROM DataChangeEvents ORDER BY TableName`); err != nil {
+				t.Fatalf("sqlscan.Select() error = %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("TableName = (%v),  want (%v)", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSQLitePatcher_InsertOrUpdateWithDataChangeEvent(t *testing.T) {
+	t.Parallel()
+
+	type Fruit struct {
+		ID      string `db:"Id"`
+		Name    string `db:"Name"`
+		Count   int64  `db:"Count"`
+		Version int64  `db:"Version,version"`
+		Deleted bool   `db:"Deleted,softdelete"`
+	}
+
+	tests := []struct {
+		name          string
+		existing      string
+		patchSet      *resource.PatchSet
+		wantErr       bool
+		wantType      EventType
+		wantChangeSet string
+		wantName      string
+		wantVersion   int64
+	}{
+		{
+			name:          "row does not exist",
+			patchSet:      resource.NewPatchSet().Set("Name", "apple").Set("Count", int64(1)),
+			wantType:      EventTypeInsert,
+			wantChangeSet: `{"Count":{"Old":0,"New":1},"Name":{"Old":"","New":"apple"}}`,
+			wantName:      "apple",
+			wantVersion:   1,
+		},
+		{
+			name:          "row exists",
+			existing:      `INSERT INTO Fruits (Id, Name, Count) VALUES ('1', 'apple', 1)`,
+			patchSet:      resource.NewPatchSet().Set("Name", "banana").Set("Count", int64(1)),
+			wantType:      EventTypeUpdate,
+			wantChangeSet: `{"Name":{"Old":"apple","New":"banana"},"Version":{"Old":1,"New":2}}`,
+			wantName:      "banana",
+			wantVersion:   2,
+		},
+		{
+			name:          "version is not checked",
+			existing:      `INSERT INTO Fruits (Id, Name, Count) VALUES ('1', 'apple', 1)`,
+			patchSet:      resource.NewPatchSet().Set("Name", "banana").Set("Count", int64(1)).Set("Version", int64(7)),
+			wantType:      EventTypeUpdate,
+			wantChangeSet: `{"Name":{"Old":"apple","New":"banana"},"Version":{"Old":1,"New":2}}`,
+			wantName:      "banana",
+			wantVersion:   2,
+		},
+		{
+			name:        "no changes",
+			existing:    `INSERT INTO Fruits (Id, Name, Count) VALUES ('1', 'apple', 1)`,
+			patchSet:    resource.NewPatchSet().Set("Name", "apple").Set("Count", int64(1)),
+			wantName:    "apple",
+			wantVersion: 1,
+		},
+		{
+			name:        "row is soft deleted",
+			existing:    `INSERT INTO Fruits (Id, Name, Count, Deleted) VALUES ('1', 'apple', 1, TRUE)`,
+			patchSet:    resource.NewPatchSet().Set("Name", "banana"),
+			wantErr:     true,
+			wantName:    "apple",
+			wantVersion: 1,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			db := newSQLiteDB(t,
+				`CREATE TABLE Fruits (Id TEXT PRIMARY KEY, Name TEXT NOT NULL, Count INTEGER NOT NULL, Version INTEGER NOT NULL DEFAULT 1, Deleted BOOLEAN NOT NULL DEFAULT FALSE)`,
+				sqliteDataChangeEvents,
+			)
+			if tt.existing != "" {
+				if _, err := db.ExecContext(ctx, tt.existing); err != nil {
+					t.Fatalf("sql.DB.ExecContext() error = %v", err)
+				}
+			}
+
+			tt.patchSet.SetKey("ID", "1")
+			mutation := &Mutation{TableName: "Fruits", RowStruct: NewRowStruct(Fruit{}), PatchSet: tt.patchSet}
+			err := NewSQLitePatcher().WithDataChangeEventColumns(EventTypeColumn, ChangeSetVersionColumn).InsertOrUpdateWithDataChangeEvent(ctx, db, "test", mutation)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SQLitePatcher.InsertOrUpdateWithDataChangeEvent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !httpio.HasConflict(err) {
+				t.Errorf("SQLitePatcher.InsertOrUpdateWithDataChangeEvent() error = %v, want conflict", err)
+			}
+
+			var name string
+			var version int64
+			if err := db.QueryRowContext(ctx, `SELECT Name, Version FROM Fruits WHERE Id = '1'`).Scan(&name, &version); err != nil {
+				t.Fatalf("sql.Row.Scan() error = %v", err)
+			}
+			if name != tt.wantName || version != tt.wantVersion {
+				t.Errorf("Name, Version = (%v, %v),  want (%v, %v)", name, version, tt.wantName, tt.wantVersion)
+			}
+
+			rows, err := db.QueryContext(ctx, `SELECT EventType, ChangeSet FROM DataChangeEvents`)
+			if err != nil {
+				t.Fatalf("sql.DB.QueryContext() error = %v", err)
+			}
+			defer rows.Close()
+
+			var events int
+			for rows.Next() {
+				events++
+
+				var eventType EventType
+				var changeSet string
+				if err := rows.Scan(&eventType, &changeSet); err != nil {
+					t.Fatalf("sql.Rows.Scan() error = %v", err)
+				}
+				if eventType != tt.wantType {
+					t.Errorf("EventType = (%v),  want (%v)", eventType, tt.wantType)
+				}
+				if changeSet != tt.wantChangeSet {
+					t.Errorf("ChangeSet = (%v),  want (%v)", changeSet, tt.wantChangeSet)
+				}
+			}
+			if wantEvents := min(len(tt.wantType), 1); events != wantEvents {
+				t.Errorf("DataChangeEvents = (%d),  want (%d)", events, wantEvents)
+			}
+		})
+	}
+}
+
+func TestSQLitePatcher_Apply_rollback(t *testing.T) {
+	t.Parallel()
+
+	type Fruit struct {
+		ID   string `db:"Id"`
+		Name string `db:"Name"`
+	}
+
+	mutation := func(operation Operation, id, name string) *Mutation {
+		patchSet := resource.NewPatchSet()
+		if name != "" {
+			patchSet.Set("Name", name)
+		}
+		patchSet.SetKey("ID", id)
+
+		return &Mutation{Operation: operation, TableName: "Fruits", RowStruct: NewRowStruct(Fruit{}), PatchSet: patchSet}
+	}
+
+	tests := []struct {
+		name      string
+		mutations []*Mutation
+		wantIndex int
+	}{
+		{
+			name: "second mutation fails",
+			mutations: []*Mutation{
+				mutation(OperationCreate, "2", "banana"),
+				mutation(OperationCreate, "1", "cherry"),
+				mutation(OperationUpdate, "1", "date"),
+			},
+			wantIndex: 1,
+		},
+		{
+			name: "last mutation fails",
+			mutations: []*Mutation{
+				mutation(OperationUpdate, "1", "banana"),
+				mutation(OperationCreate, "2", "cherry"),
+				mutation(OperationDelete, "3", ""),
+			},
+			wantIndex: 2,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			db := newSQLiteDB(t,
+				`CREATE TABLE Fruits (Id TEXT PRIMARY KEY, Name TEXT NOT NULL)`,
+				sqliteDataChangeEvents,
+				`INSERT INTO Fruits (Id, Name) VALUES ('1', 'apple')`,
+			)
+
+			err := NewSQLitePatcher().Apply(ctx, db, "test", tt.mutations...)
+
+			var mutationErr *MutationError
+			if !errors.As(err, &mutationErr) {
+				t.Fatalf("SQLitePatcher.Apply() error = %v, want *MutationError", err)
+			}
+			if mutationErr.Index != tt.wantIndex {
+				t.Errorf("MutationError.Index = (%v),  want (%v)", mutationErr.Index, tt.wantIndex)
+			}
+			if mutationErr.Mutation != tt.mutations[tt.wantIndex] {
+				t.Errorf("MutationError.Mutation = (%v),  want (%v)", mutationErr.Mutation, tt.mutations[tt.wantIndex])
+			}
+
+			// The changes of the mutations before the failed one are rolled back
+			var fruits []string
+			rows, err := db.QueryContext(ctx, `SELECT Id || ':' || Name FROM Fruits ORDER BY Id`)
+			if err != nil {
+				t.Fatalf("sql.DB.QueryContext() error = %v", err)
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var fruit string
+				if err := rows.Scan(&fruit); err != nil {
+					t.Fatalf("sql.Rows.Scan() error = %v", err)
+				}
+				fruits = append(fruits, fruit)
+			}
+			if want := []string{"1:apple"}; !slices.Equal(fruits, want) {
+				t.Errorf("Fruits = (%v),  want (%v)", fruits, want)
+			}
+
+			var count int
+			if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM DataChangeEvents`).Scan(&count); err != nil {
+				t.Fatalf("sql.Row.Scan() error = %v", err)
+			}
+			if count != 0 {
+				t.Errorf("DataChangeEvents count = (%v),  want (%v)", count, 0)
+			}
+		})
+	}
+}
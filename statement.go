@@ -0,0 +1,223 @@
+package patcher
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/cccteam/ccc/accesstypes"
+	"github.com/cccteam/ccc/resource"
+	"github.com/go-playground/errors/v5"
+)
+
+// sqlExpr is a SQL expression written into a statement verbatim instead of being bound as a parameter
+type sqlExpr string
+
+// statement collects the parameters of a SQL statement as they are placed
+type statement struct {
+	dialect Dialect
+	params  []Param
+	names   map[string]struct{}
+}
+
+func newStatement(dialect Dialect) *statement {
+	return &statement{
+		dialect: dialect,
+		names:   make(map[string]struct{}),
+	}
+}
+
+// bind adds value to the statement and returns its placeholder. Names are made unique within the statement.
+func (s *statement) bind(name string, value any) string {
+	if expr, ok := value.(sqlExpr); ok {
+		return string(expr)
+	}
+
+	if _, found := s.names[name]; found {
+		name = fmt.Sprintf("%s_%d", name, len(s.params)+1)
+	}
+	s.names[name] = struct{}{}
+	s.params = append(s.params, Param{Name: name, Value: value})
+
+	return s.dialect.Placeholder(name, len(s.params))
+}
+
+func (s *statement) args() []any {
+	return s.dialect.BindArgs(s.params)
+}
+
+// where builds the where clause matching keySet, binding the key values to stmt.
+// Rows which have been soft deleted are excluded.
+func (p *patcher) where(keySet resource.KeySet, databaseType any, stmt *statement) (string, error) {
+	conditions, err := p.keyConditions(keySet, databaseType, stmt)
+	if err != nil {
+		return "", err
+	}
+
+	softDelete, err := p.softDelete(databaseType)
+	if err != nil {
+		return "", err
+	}
+	if softDelete != nil {
+		conditions = append(conditions, softDelete.condition(p.dialect))
+	}
+
+	return strings.Join(conditions, " AND "), nil
+}
+
+// keyConditions returns the conditions matching keySet, binding the key values to stmt. Soft deleted rows are not excluded.
+func (p *patcher) keyConditions(keySet resource.KeySet, databaseType any, stmt *statement) ([]string, error) {
+	parts := keySet.Parts()
+	if len(parts) == 0 {
+		return nil, errors.New("KeySet must include at least one key in call to Where")
+	}
+
+	fieldTagMapping, err := p.get(databaseType)
+	if err != nil {
+		return nil, err
+	}
+
+	conditions := make([]string, 0, len(parts))
+	for _, part := range parts {
+		c, ok := fieldTagMapping[part.Key]
+		if !ok {
+			return nil, errors.Newf("field %s not found in struct", part.Key)
+		}
+		conditions = append(conditions, fmt.Sprintf("%s = %s", p.dialect.QuoteIdentifier(c.tag), stmt.bind(strings.ToLower(c.tag), part.Value)))
+	}
+
+	return conditions, nil
+}
+
+// assignments returns the quoted columns of patch, sorted by column name, and the placeholders bound to stmt for their values
+func (p *patcher) assignments(patch map[string]any, stmt *statement) (columns, values []string) {
+	tags := slices.Sorted(maps.Keys(patch))
+
+	columns = make([]string, 0, len(tags))
+	values = make([]string, 0, len(tags))
+	for _, tag := range tags {
+		columns = append(columns, p.dialect.QuoteIdentifier(tag))
+		values = append(values, stmt.bind(strings.ToLower(tag), patch[tag]))
+	}
+
+	return columns, values
+}
+
+func (p *patcher) insertStatement(tableName string, patch map[string]any) (string, []any) {
+	stmt := newStatement(p.dialect)
+	columns, values := p.assignments(patch, stmt)
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		p.dialect.QuoteIdentifier(tableName), strings.Join(columns, ", "), strings.Join(values, ", "),
+	), stmt.args()
+}
+
+func (p *patcher) upsertStatement(tableName accesstypes.Resource, patch map[string]any, keySet resource.KeySet, databaseType any) (string, []any, error) {
+	keys, err := p.keyColumns(keySet, databaseType)
+	if err != nil {
+		return "", nil, err
+	}
+
+	keyColumns := make([]string, 0, len(keys))
+	for _, key := range keys {
+		keyColumns = append(keyColumns, p.dialect.QuoteIdentifier(key))
+	}
+
+	stmt := newStatement(p.dialect)
+	columns, values := p.assignments(patch, stmt)
+
+	return p.dialect.Upsert(p.dialect.QuoteIdentifier(string(tableName)), columns, values, keyColumns), stmt.args(), nil
+}
+
+func (p *patcher) updateStatement(tableName accesstypes.Resource, patch map[string]any, keySet resource.KeySet, databaseType any) (string, []any, error) {
+	stmt := newStatement(p.dialect)
+	columns, values := p.assignments(patch, stmt)
+
+	set := make([]string, 0, len(columns))
+	for i := range columns {
+		set = append(set, fmt.Sprintf("%s = %s", columns[i], values[i]))
+	}
+
+	where, err := p.where(keySet, databaseType, stmt)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return fmt.Sprintf("UPDATE %s SET %s WHERE %s",
+		p.dialect.QuoteIdentifier(string(tableName)), strings.Join(set, ", "), where,
+	), stmt.args(), nil
+}
+
+// deleteStatement deletes the row matching keySet, or marks it as deleted when databaseType uses soft deletes
+func (p *patcher) deleteStatement(tableName accesstypes.Resource, keySet resource.KeySet, databaseType any) (string, []any, error) {
+	softDelete, err := p.softDelete(databaseType)
+	if err != nil {
+		return "", nil, err
+	}
+	if softDelete != nil {
+		return p.updateStatement(tableName, map[string]any{softDelete.tag: softDelete.value(sqlExpr(p.dialect.CommitTimestamp()))}, keySet, databaseType)
+	}
+
+	stmt := newStatement(p.dialect)
+	where, err := p.where(keySet, databaseType, stmt)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return fmt.Sprintf("DELETE FROM %s WHERE %s", p.dialect.QuoteIdentifier(string(tableName)), where), stmt.args(), nil
+}
+
+// selectForUpdateStatement selects columns from the row matching keySet, locking it when supported by the Dialect
+func (p *patcher) selectForUpdateStatement(columns string, tableName accesstypes.Resource, keySet resource.KeySet, databaseType any) (string, []any, error) {
+	stmt := newStatement(p.dialect)
+	where, err := p.where(keySet, databaseType, stmt)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return p.selectForUpdate(columns, tableName, where), stmt.args(), nil
+}
+
+// selectAnyForUpdateStatement is like selectForUpdateStatement, but also selects the row when it has been soft deleted
+func (p *patcher) selectAnyForUpdateStatement(columns string, tableName accesstypes.Resource, keySet resource.KeySet, databaseType any) (string, []any, error) {
+	stmt := newStatement(p.dialect)
+	conditions, err := p.keyConditions(keySet, databaseType, stmt)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return p.selectForUpdate(columns, tableName, strings.Join(conditions, " AND ")), stmt.args(), nil
+}
+
+func (p *patcher) selectForUpdate(columns string, tableName accesstypes.Resource, where string) string {
+	return fmt.Sprintf(`
+			SELECT
+				%s
+			FROM %s
+			WHERE %s
+			%s`, columns, p.dialect.QuoteIdentifier(string(tableName)), where, p.dialect.ForUpdate(),
+	)
+}
+
+// dataChangeEventPatch maps the columns of event to their values, with EventTime set to commitTimestamp.
+// Optional columns are left out unless they are enabled in columns, so the table only needs them once they are enabled.
+func (p *patcher) dataChangeEventPatch(event *DataChangeEvent, commitTimestamp any, columns eventColumns) (map[string]any, error) {
+	fieldTagMapping, err := p.get(event)
+	if err != nil {
+		return nil, err
+	}
+
+	eventValue := reflect.ValueOf(event).Elem()
+	patch := make(map[string]any, len(fieldTagMapping))
+	for field, c := range fieldTagMapping {
+		if !columns.writes(c.tag) {
+			continue
+		}
+		patch[c.tag] = eventValue.FieldByName(string(field)).Interface()
+	}
+	patch[fieldTagMapping["EventTime"].tag] = commitTimestamp
+
+	return patch, nil
+}
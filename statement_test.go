@@ -0,0 +1,191 @@
+package patcher
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cccteam/ccc/resource"
+)
+
+func TestPatcher_statements(t *testing.T) {
+	t.Parallel()
+
+	type DBStruct struct {
+		ID    string `db:"Id" spanner:"Id"`
+		Name  string `db:"Name" spanner:"Name"`
+		Count int    `db:"Count" spanner:"Count"`
+	}
+
+	patch := map[string]any{"Id": "1", "Name": "apple", "Count": 2}
+	keySet := resource.NewKeySet("ID", "1")
+
+	tests := []struct {
+		name       string
+		dialect    Dialect
+		wantInsert string
+		wantUpsert string
+		wantUpdate string
+		wantDelete string
+	}{
+		{
+			name:       "postgres",
+			dialect:    PostgresDialect{},
+			wantInsert: `INSERT INTO "Fruits" ("Count", "Id", "Name") VALUES (@count, @id, @name)`,
+			wantUpsert: `INSERT INTO "Fruits" ("Count", "Id", "Name") VALUES (@count, @id, @name) ON CONFLICT ("Id") DO UPDATE SET "Count" = EXCLUDED."Count", "Name" = EXCLUDED."Name"`,
+			wantUpdate: `UPDATE "Fruits" SET "Count" = @count, "Id" = @id, "Name" = @name WHERE "Id" = @id_4`,
+			wantDelete: `DELETE FROM "Fruits" WHERE "Id" = @id`,
+		},
+		{
+			name:       "spanner",
+			dialect:    SpannerDialect{},
+			wantInsert: `INSERT INTO Fruits (Count, Id, Name) VALUES (@count, @id, @name)`,
+			wantUpsert: `INSERT OR UPDATE INTO Fruits (Count, Id, Name) VALUES (@count, @id, @name)`,
+			wantUpdate: `UPDATE Fruits SET Count = @count, Id = @id, Name = @name WHERE Id = @id_4`,
+			wantDelete: `DELETE FROM Fruits WHERE Id = @id`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := newPatcher("db", tt.dialect)
+
+			gotInsert, _ := p.insertStatement("Fruits", patch)
+			if gotInsert != tt.wantInsert {
+				t.Errorf("patcher.insertStatement() = (%v),  want (%v)", gotInsert, tt.wantInsert)
+			}
+
+			gotUpsert, _, err := p.upsertStatement("Fruits", patch, keySet, DBStruct{})
+			if err != nil {
+				t.Fatalf("patcher.upsertStatement() error = %v", err)
+			}
+			if gotUpsert != tt.wantUpsert {
+				t.Errorf("patcher.upsertStatement() = (%v),  want (%v)", gotUpsert, tt.wantUpsert)
+			}
+
+			gotUpdate, _, err := p.updateStatement("Fruits", patch, keySet, DBStruct{})
+			if err != nil {
+				t.Fatalf("patcher.updateStatement() error = %v", err)
+			}
+			if gotUpdate != tt.wantUpdate {
+				t.Errorf("patcher.updateStatement() = (%v),  want (%v)", gotUpdate, tt.wantUpdate)
+			}
+
+			gotDelete, _, err := p.deleteStatement("Fruits", keySet, DBStruct{})
+			if err != nil {
+				t.Fatalf("patcher.deleteStatement() error = %v", err)
+			}
+			if gotDelete != tt.wantDelete {
+				t.Errorf("patcher.deleteStatement() = (%v),  want (%v)", gotDelete, tt.wantDelete)
+			}
+		})
+	}
+}
+
+func TestPatcher_softDelete(t *testing.T) {
+	t.Parallel()
+
+	type Fruit struct {
+		ID        string     `db:"Id"`
+		Name      string     `db:"Name"`
+		DeletedAt *time.Time `db:"DeletedAt,softdelete"`
+	}
+
+	type Vegetable struct {
+		ID      string `spanner:"Id"`
+		Deleted bool   `spanner:"Deleted" patcher:"softdelete"`
+	}
+
+	keySet := resource.NewKeySet("ID", "1")
+
+	tests := []struct {
+		name         string
+		patcher      *patcher
+		databaseType any
+		wantDelete   string
+		wantWhere    string
+	}{
+		{
+			name:         "timestamp",
+			patcher:      newPatcher("db", PostgresDialect{}),
+			databaseType: Fruit{},
+			wantDelete:   `UPDATE "Fruits" SET "DeletedAt" = CURRENT_TIMESTAMP WHERE "Id" = @id AND "DeletedAt" IS NULL`,
+			wantWhere:    `"Id" = @id AND "DeletedAt" IS NULL`,
+		},
+		{
+			name:         "flag",
+			patcher:      newPatcher("spanner", SpannerDialect{}),
+			databaseType: Vegetable{},
+			wantDelete:   `UPDATE Fruits SET Deleted = @deleted WHERE Id = @id AND Deleted IS NOT TRUE`,
+			wantWhere:    `Id = @id AND Deleted IS NOT TRUE`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			gotDelete, _, err := tt.patcher.deleteStatement("Fruits", keySet, tt.databaseType)
+			if err != nil {
+				t.Fatalf("patcher.deleteStatement() error = %v", err)
+			}
+			if gotDelete != tt.wantDelete {
+				t.Errorf("patcher.deleteStatement() = (%v),  want (%v)", gotDelete, tt.wantDelete)
+			}
+
+			gotWhere, _, err := tt.patcher.Where(keySet, tt.databaseType)
+			if err != nil {
+				t.Fatalf("patcher.Where() error = %v", err)
+			}
+			if gotWhere != tt.wantWhere {
+				t.Errorf("patcher.Where() = (%v),  want (%v)", gotWhere, tt.wantWhere)
+			}
+		})
+	}
+}
+
+func TestPatcher_dataChangeEventPatch(t *testing.T) {
+	t.Parallel()
+
+	revertedEventTime := time.Date(2032, 4, 23, 12, 2, 3, 4, time.UTC)
+	p := newPatcher("db", PostgresDialect{})
+
+	tests := []struct {
+		name    string
+		columns eventColumns
+		event   *DataChangeEvent
+		want    []string
+	}{
+		{
+			name:  "baseline columns",
+			event: &DataChangeEvent{TableName: "Fruits", RowID: "1", EventType: EventTypeInsert, RevertedEventTime: &revertedEventTime},
+			want:  []string{"ChangeSet", "EventSource", "EventTime", "RowId", "TableName"},
+		},
+		{
+			name:    "optional columns enabled",
+			columns: eventColumns{EventTypeColumn, RevertedEventTimeColumn},
+			event:   &DataChangeEvent{TableName: "Fruits", RowID: "1", EventType: EventTypeInsert},
+			want:    []string{"ChangeSet", "EventSource", "EventTime", "EventType", "RevertedEventTime", "RowId", "TableName"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := p.dataChangeEventPatch(tt.event, sqlExpr("CURRENT_TIMESTAMP"), tt.columns)
+			if err != nil {
+				t.Fatalf("patcher.dataChangeEventPatch() error = %v", err)
+			}
+			if columns := slices.Sorted(maps.Keys(got)); !slices.Equal(columns, tt.want) {
+				t.Errorf("patcher.dataChangeEventPatch() columns = (%v),  want (%v)", columns, tt.want)
+			}
+			if got["EventTime"] != sqlExpr("CURRENT_TIMESTAMP") {
+				t.Errorf("patcher.dataChangeEventPatch() EventTime = (%v),  want (%v)", got["EventTime"], "CURRENT_TIMESTAMP")
+			}
+		})
+	}
+}
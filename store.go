@@ -0,0 +1,70 @@
+package patcher
+
+import "context"
+
+// Store writes mutations to the database it is bound to. Unlike Patcher, its methods do not take the
+// database handle, whose type depends on the backend, so service code can be written against Store
+// and be given a Store of any backend.
+type Store interface {
+	Insert(ctx context.Context, mutation *Mutation) error
+	Update(ctx context.Context, mutation *Mutation) error
+	InsertOrUpdate(ctx context.Context, mutation *Mutation) error
+	Delete(ctx context.Context, mutation *Mutation) error
+	InsertWithDataChangeEvent(ctx context.Context, eventSource string, mutation *Mutation) error
+	UpdateWithDataChangeEvent(ctx context.Context, eventSource string, mutation *Mutation) error
+	InsertOrUpdateWithDataChangeEvent(ctx context.Context, eventSource string, mutation *Mutation) error
+	DeleteWithDataChangeEvent(ctx context.Context, eventSource string, mutation *Mutation) error
+	Mutate(ctx context.Context, eventSource string, mutation *Mutation) error
+	Apply(ctx context.Context, eventSource string, mutations ...*Mutation) error
+}
+
+// NewStore returns a Store writing to db with p, e.g. NewStore(NewSpannerPatcher(), client),
+// or NewStore[SQLBeginner](NewSQLitePatcher(), db) when the type of db is not the one of the Patcher.
+func NewStore[DB any](p Patcher[DB], db DB) Store {
+	return &store[DB]{patcher: p, db: db}
+}
+
+type store[DB any] struct {
+	patcher Patcher[DB]
+	db      DB
+}
+
+func (s *store[DB]) Insert(ctx context.Context, mutation *Mutation) error {
+	return s.patcher.Insert(ctx, s.db, mutation)
+}
+
+func (s *store[DB]) Update(ctx context.Context, mutation *Mutation) error {
+	return s.patcher.Update(ctx, s.db, mutation)
+}
+
+func (s *store[DB]) InsertOrUpdate(ctx context.Context, mutation *Mutation) error {
+	return s.patcher.InsertOrUpdate(ctx, s.db, mutation)
+}
+
+func (s *store[DB]) Delete(ctx context.Context, mutation *Mutation) error {
+	return s.patcher.Delete(ctx, s.db, mutation)
+}
+
+func (s *store[DB]) InsertWithDataChangeEvent(ctx context.Context, eventSource string, mutation *Mutation) error {
+	return s.patcher.InsertWithDataChangeEvent(ctx, s.db, eventSource, mutation)
+}
+
+func (s *store[DB]) UpdateWithDataChangeEvent(ctx context.Context, eventSource string, mutation *Mutation) error {
+	return s.patcher.UpdateWithDataChangeEvent(ctx, s.db, eventSource, mutation)
+}
+
+func (s *store[DB]) InsertOrUpdateWithDataChangeEvent(ctx context.Context, eventSource string, mutation *Mutation) error {
+	return s.patcher.InsertOrUpdateWithDataChangeEvent(ctx, s.db, eventSource, mutation)
+}
+
+func (s *store[DB]) DeleteWithDataChangeEvent(ctx context.Context, eventSource string, mutation *Mutation) error {
+	return s.patcher.DeleteWithDataChangeEvent(ctx, s.db, eventSource, mutation)
+}
+
+func (s *store[DB]) Mutate(ctx context.Context, eventSource string, mutation *Mutation) error {
+	return s.patcher.Mutate(ctx, s.db, eventSource, mutation)
+}
+
+func (s *store[DB]) Apply(ctx context.Context, eventSource string, mutations ...*Mutation) error {
+	return s.patcher.Apply(ctx, s.db, eventSource, mutations...)
+}
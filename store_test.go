@@ -0,0 +1,43 @@
+package patcher
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cccteam/ccc/resource"
+)
+
+func TestNewStore(t *testing.T) {
+	t.Parallel()
+
+	type Fruit struct {
+		ID   string `db:"Id"`
+		Name string `db:"Name"`
+	}
+
+	ctx := context.Background()
+	db := newSQLiteDB(t,
+		`CREATE TABLE Fruits (Id TEXT PRIMARY KEY, Name TEXT NOT NULL)`,
+		sqliteDataChangeEvents,
+	)
+
+	// Service code only depends on Store
+	createFruit := func(ctx context.Context, store Store, id, name string) error {
+		patchSet := resource.NewPatchSet().Set("Name", name)
+		patchSet.SetKey("ID", id)
+
+		return store.InsertWithDataChangeEvent(ctx, "test", &Mutation{TableName: "Fruits", RowStruct: NewRowStruct(Fruit{}), PatchSet: patchSet})
+	}
+
+	if err := createFruit(ctx, NewStore[SQLBeginner](NewSQLitePatcher(), db), "1", "apple"); err != nil {
+		t.Fatalf("Store.InsertWithDataChangeEvent() error = %v", err)
+	}
+
+	var name string
+	if err := db.QueryRowContext(ctx, `SELECT Name FROM Fruits WHERE Id = '1'`).Scan(&name); err != nil {
+		t.Fatalf("sql.Row.Scan() error = %v", err)
+	}
+	if name != "apple" {
+		t.Errorf("Name = (%v),  want (%v)", name, "apple")
+	}
+}
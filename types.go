@@ -1,6 +1,8 @@
 package patcher
 
 import (
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/cccteam/ccc/accesstypes"
@@ -29,17 +31,106 @@ func (r *row[T]) Type() any {
 }
 
 type DataChangeEvent struct {
-	TableName   accesstypes.Resource `spanner:"TableName"`
-	RowID       string               `spanner:"RowId"`
-	EventTime   time.Time            `spanner:"EventTime"`
-	EventSource string               `spanner:"EventSource"`
-	ChangeSet   string               `spanner:"ChangeSet"`
+	TableName   accesstypes.Resource `spanner:"TableName"   db:"TableName"`
+	RowID       string               `spanner:"RowId"       db:"RowId"`
+	EventTime   time.Time            `spanner:"EventTime"   db:"EventTime"`
+	EventSource string               `spanner:"EventSource" db:"EventSource"`
+	ChangeSet   string               `spanner:"ChangeSet"   db:"ChangeSet"`
+
+	// EventType is the kind of change recorded by the event. It is only recorded when EventTypeColumn is enabled,
+	// and is empty for events recorded before, use Type to infer it from the ChangeSet.
+	EventType EventType `spanner:"EventType" db:"EventType"`
+
+	// ChangeSetVersion is the version of the format of ChangeSet. It is only recorded when ChangeSetVersionColumn
+	// is enabled, and is 0 for events recorded before.
+	ChangeSetVersion int64 `spanner:"ChangeSetVersion" db:"ChangeSetVersion"`
+
+	// CorrelationID groups the events of related changes, and Reason explains them. They are set from the context
+	// of the change, see WithCorrelationID and WithChangeReason, and are only recorded when CorrelationIDColumn
+	// and ReasonColumn are enabled.
+	CorrelationID *string `spanner:"CorrelationId" db:"CorrelationId"`
+	Reason        *string `spanner:"Reason"        db:"Reason"`
+
+	// RevertedEventTime is the EventTime of the event undone by this event, set by Revert.
+	// It is only recorded when RevertedEventTimeColumn is enabled.
+	RevertedEventTime *time.Time `spanner:"RevertedEventTime" db:"RevertedEventTime"`
 }
 
+// Source parses the EventSource of the event
+func (e *DataChangeEvent) Source() (*EventSource, error) {
+	return ParseEventSource(e.EventSource)
+}
+
+// ChangeSetVersion is the version of the ChangeSet format written by this package
+const ChangeSetVersion int64 = 1
+
+// EventType is the kind of change recorded by a DataChangeEvent
+type EventType string
+
+const (
+	EventTypeInsert EventType = "Insert"
+	EventTypeUpdate EventType = "Update"
+	// EventTypeUpsert is an insert or an update, when it is not known which. It was recorded by upserts before they read
+	// the existing row, and is inferred by Type for events recorded without the EventType column which could be inserts.
+	EventTypeUpsert  EventType = "Upsert"
+	EventTypeDelete  EventType = "Delete"
+	EventTypeRestore EventType = "Restore"
+)
+
+// Type returns the EventType of the event, inferring it from the ChangeSet when the event was recorded without the EventType
+// column, using keySet, the keys of its row. Only deletes and restores record the key fields, a delete with no New values.
+// An event with a non-zero Old value is an update. Otherwise it is EventTypeUpsert, since an insert and an update from
+// zero values look the same.
+func (e *DataChangeEvent) Type(keySet resource.KeySet) (EventType, error) {
+	if e.EventType != "" {
+		return e.EventType, nil
+	}
+
+	changeSet, err := parseChangeSet(e.ChangeSet)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case !changeSet.hasKeys(keySet) && changeSet.hasZeroOld():
+		return EventTypeUpsert, nil
+	case !changeSet.hasKeys(keySet):
+		return EventTypeUpdate, nil
+	case changeSet.isDelete():
+		return EventTypeDelete, nil
+	default:
+		return EventTypeRestore, nil
+	}
+}
+
+// Operation is the kind of write performed by a Mutation
+type Operation string
+
+const (
+	OperationCreate Operation = "Create"
+	OperationUpdate Operation = "Update"
+	OperationUpsert Operation = "Upsert"
+	OperationDelete Operation = "Delete"
+)
+
 type Mutation struct {
+	Operation Operation
 	TableName accesstypes.Resource
 	RowStruct RowStruct
 	PatchSet  *resource.PatchSet
+
+	// Preconditions are the values the fields of the row are expected to have before it is updated.
+	// They are checked against the existing row by UpdateWithDataChangeEvent, which fails with a conflict
+	// wrapping a *PreconditionError when they do not match.
+	Preconditions map[accesstypes.Field]any
+}
+
+// withPatchSet returns a copy of m writing patchSet
+func (m *Mutation) withPatchSet(patchSet *resource.PatchSet) *Mutation {
+	c := *m
+	c.PatchSet = patchSet
+
+	return &c
 }
 
 type DiffElem struct {
@@ -47,7 +138,47 @@ type DiffElem struct {
 	New any
 }
 
+// MutationError identifies the mutation that failed in a call to Apply
+type MutationError struct {
+	Index    int
+	Mutation *Mutation
+	Err      error
+}
+
+func (e *MutationError) Error() string {
+	return fmt.Sprintf("mutation %d (%s %s): %s", e.Index, e.Mutation.Operation, e.Mutation.TableName, e.Err)
+}
+
+func (e *MutationError) Unwrap() error {
+	return e.Err
+}
+
+// PreconditionError lists the fields of a row which did not match the Preconditions of a Mutation
+type PreconditionError struct {
+	TableName  accesstypes.Resource
+	RowID      string
+	Mismatches []PreconditionMismatch
+}
+
+// PreconditionMismatch is a field which did not have its Expected value
+type PreconditionMismatch struct {
+	Field    accesstypes.Field
+	Expected any
+	Actual   any
+}
+
+func (e *PreconditionError) Error() string {
+	fields := make([]string, 0, len(e.Mismatches))
+	for _, m := range e.Mismatches {
+		fields = append(fields, fmt.Sprintf("%s is %v, expected %v", m.Field, m.Actual, m.Expected))
+	}
+
+	return fmt.Sprintf("preconditions failed on %s (%s): %s", e.TableName, e.RowID, strings.Join(fields, ", "))
+}
+
 type cacheEntry struct {
-	index int
-	tag   string
+	index      int
+	tag        string
+	version    bool
+	softDelete bool
 }
@@ -0,0 +1,41 @@
+package patcher
+
+import (
+	"testing"
+
+	"github.com/cccteam/ccc/resource"
+)
+
+func TestDataChangeEvent_Type(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		event   *DataChangeEvent
+		want    EventType
+		wantErr bool
+	}{
+		{name: "recorded", event: &DataChangeEvent{EventType: EventTypeUpdate, ChangeSet: `{"Count":{"Old":0,"New":1}}`}, want: EventTypeUpdate},
+		{name: "legacy insert", event: &DataChangeEvent{ChangeSet: `{"Count":{"Old":0,"New":1},"Name":{"Old":"","New":"apple"}}`}, want: EventTypeUpsert},
+		{name: "legacy update from zero values", event: &DataChangeEvent{ChangeSet: `{"Count":{"Old":0,"New":5}}`}, want: EventTypeUpsert},
+		{name: "legacy update", event: &DataChangeEvent{ChangeSet: `{"Count":{"Old":0,"New":5},"Name":{"Old":"apple","New":"pear"}}`}, want: EventTypeUpdate},
+		{name: "legacy update to null", event: &DataChangeEvent{ChangeSet: `{"Color":{"Old":"red","New":null}}`}, want: EventTypeUpdate},
+		{name: "legacy delete", event: &DataChangeEvent{ChangeSet: `{"Count":{"Old":1,"New":null},"ID":{"Old":"1","New":null}}`}, want: EventTypeDelete},
+		{name: "legacy restore", event: &DataChangeEvent{ChangeSet: `{"Count":{"Old":null,"New":1},"ID":{"Old":null,"New":"1"}}`}, want: EventTypeRestore},
+		{name: "invalid ChangeSet", event: &DataChangeEvent{ChangeSet: `{`}, wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := tt.event.Type(resource.NewKeySet("ID", "1"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DataChangeEvent.Type() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("DataChangeEvent.Type() = (%v),  want (%v)", got, tt.want)
+			}
+		})
+	}
+}
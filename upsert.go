@@ -0,0 +1,114 @@
+package patcher
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+
+	"github.com/cccteam/ccc/accesstypes"
+	"github.com/cccteam/ccc/resource"
+	"github.com/cccteam/httpio"
+	"github.com/go-playground/errors/v5"
+)
+
+// upsertColumns returns the columns read from the existing row of an upsert: the columns of patchSet and
+// preconditions, and the version and soft delete columns of databaseType.
+func (p *patcher) upsertColumns(patchSet *resource.PatchSet, preconditions map[accesstypes.Field]any, databaseType any) (string, error) {
+	fields := slices.Clone(patchSet.Fields())
+	for field := range preconditions {
+		if !slices.Contains(fields, field) {
+			fields = append(fields, field)
+		}
+	}
+
+	versionField, ok, err := p.versionField(databaseType)
+	if err != nil {
+		return "", err
+	}
+	if ok && !slices.Contains(fields, versionField) {
+		fields = append(fields, versionField)
+	}
+
+	softDelete, err := p.softDelete(databaseType)
+	if err != nil {
+		return "", err
+	}
+	if softDelete != nil && !slices.Contains(fields, softDelete.field) {
+		fields = append(fields, softDelete.field)
+	}
+
+	return p.columns(fields, databaseType)
+}
+
+// jsonUpsertSet returns the EventType and ChangeSet of upserting patchSet onto old, the existing row read with upsertColumns,
+// or nil when the row does not exist, along with the PatchSet to write. The ChangeSet is nil when the upsert changes nothing.
+//
+// The version of a versioned row is not checked, a version in patchSet is ignored, and the version is incremented when the
+// row changes. A soft deleted row is a conflict, since the upsert would update a row which does not exist for reads.
+func (p *patcher) jsonUpsertSet(
+	tableName accesstypes.Resource, keySet resource.KeySet, old any, patchSet *resource.PatchSet, preconditions map[accesstypes.Field]any, row RowStruct,
+) (EventType, []byte, *resource.PatchSet, error) {
+	if old == nil {
+		jsonChangeSet, err := p.jsonInsertSet(patchSet, row)
+		if err != nil {
+			return "", nil, nil, err
+		}
+
+		return EventTypeInsert, jsonChangeSet, patchSet, nil
+	}
+
+	softDelete, err := p.softDelete(row.Type())
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if softDelete != nil && softDelete.deleted(old) {
+		return "", nil, nil, httpio.NewConflictMessagef("%s (%s) has been deleted", tableName, keySet.String())
+	}
+
+	if err := checkPreconditions(tableName, keySet, old, preconditions); err != nil {
+		return "", nil, nil, err
+	}
+
+	versionField, versioned, err := p.versionField(row.Type())
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if versioned {
+		patchSet = withoutField(patchSet, versionField)
+	}
+
+	changeSet, err := p.Diff(old, patchSet)
+	if err != nil {
+		return "", nil, nil, errors.Wrap(err, "Diff()")
+	}
+
+	if len(changeSet) == 0 {
+		return EventTypeUpdate, nil, patchSet, nil
+	}
+
+	if versioned {
+		oldVersion := reflect.Indirect(reflect.ValueOf(old)).FieldByName(string(versionField))
+		newVersion, err := nextVersion(oldVersion)
+		if err != nil {
+			return "", nil, nil, errors.Wrapf(err, "field %s", versionField)
+		}
+		patchSet.Set(versionField, newVersion)
+		changeSet[versionField] = DiffElem{Old: oldVersion.Interface(), New: newVersion}
+	}
+
+	if err := auditChangeSet(rowStructType(row), changeSet, p.audit.hashKey); err != nil {
+		return "", nil, nil, err
+	}
+
+	if len(changeSet) == 0 {
+		// Only fields with the audit option omit changed, so there is nothing to record
+		return EventTypeUpdate, nil, patchSet, nil
+	}
+
+	jsonChangeSet, err := json.Marshal(changeSet)
+	if err != nil {
+		return "", nil, nil, errors.Wrap(err, "json.Marshal()")
+	}
+
+	return EventTypeUpdate, jsonChangeSet, patchSet, nil
+}
@@ -0,0 +1,104 @@
+package patcher
+
+import (
+	"reflect"
+
+	"github.com/cccteam/ccc/accesstypes"
+	"github.com/cccteam/ccc/resource"
+	"github.com/cccteam/httpio"
+	"github.com/go-playground/errors/v5"
+)
+
+// versionField returns the field of databaseType tagged with the version option, e.g. `spanner:"Version" patcher:"version"`
+func (p *patcher) versionField(databaseType any) (field accesstypes.Field, ok bool, err error) {
+	fieldTagMapping, err := p.get(databaseType)
+	if err != nil {
+		return "", false, err
+	}
+
+	for structField, c := range fieldTagMapping {
+		if c.version {
+			return structField, true, nil
+		}
+	}
+
+	return "", false, nil
+}
+
+// incrementVersion checks the version supplied in patchSet against old, the current row, and returns a copy of
+// patchSet with the version incremented along with the DiffElem for the version. When databaseType does not
+// have a version field, patchSet is returned unchanged.
+func (p *patcher) incrementVersion(
+	tableName accesstypes.Resource, keySet resource.KeySet, old any, patchSet *resource.PatchSet, databaseType any,
+) (*resource.PatchSet, map[accesstypes.Field]DiffElem, error) {
+	field, ok, err := p.versionField(databaseType)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		return patchSet, nil, nil
+	}
+
+	version, ok := patchSet.Data()[field]
+	if !ok {
+		return nil, nil, httpio.NewBadRequestMessagef("%s is required to update %s (%s)", field, tableName, keySet.String())
+	}
+
+	oldVersion := reflect.Indirect(reflect.ValueOf(old)).FieldByName(string(field))
+	if match, err := match(oldVersion.Interface(), version); err != nil {
+		return nil, nil, err
+	} else if !match {
+		return nil, nil, httpio.NewConflictMessagef("%s (%s) has been modified, %s is %v", tableName, keySet.String(), field, oldVersion.Interface())
+	}
+
+	newVersion, err := nextVersion(oldVersion)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "field %s", field)
+	}
+
+	versioned := clonePatchSet(patchSet).Set(field, newVersion)
+
+	return versioned, map[accesstypes.Field]DiffElem{field: {Old: oldVersion.Interface(), New: newVersion}}, nil
+}
+
+// nextVersion returns v incremented by one, keeping its type
+func nextVersion(v reflect.Value) (any, error) {
+	next := reflect.New(v.Type()).Elem()
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		next.SetInt(v.Int() + 1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		next.SetUint(v.Uint() + 1)
+	default:
+		return nil, errors.Newf("version must be an integer, found kind %s", v.Kind())
+	}
+
+	return next.Interface(), nil
+}
+
+func clonePatchSet(patchSet *resource.PatchSet) *resource.PatchSet {
+	clone := resource.NewPatchSet()
+	for _, field := range patchSet.Fields() {
+		clone.Set(field, patchSet.Get(field))
+	}
+	for _, part := range patchSet.KeySet().Parts() {
+		clone.SetKey(part.Key, part.Value)
+	}
+
+	return clone
+}
+
+// withoutField returns a copy of patchSet without field
+func withoutField(patchSet *resource.PatchSet, field accesstypes.Field) *resource.PatchSet {
+	clone := resource.NewPatchSet()
+	for _, f := range patchSet.Fields() {
+		if f != field {
+			clone.Set(f, patchSet.Get(f))
+		}
+	}
+	for _, part := range patchSet.KeySet().Parts() {
+		clone.SetKey(part.Key, part.Value)
+	}
+
+	return clone
+}